@@ -0,0 +1,168 @@
+package simpleapi
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+)
+
+var errorType = reflect.TypeOf((*error)(nil)).Elem()
+
+/**
+ * 通过Provide注册的单例组件定义（缓存、HTTP客户端、消息生产者、配置结构体等）
+ */
+type componentDef struct {
+	name          string
+	componentType reflect.Type
+	constructor   reflect.Value //构造函数，为空时表示直接注册的组件实例
+	value         reflect.Value
+	constructed   bool
+	constructing  bool //构造中标记，用于发现构造函数之间的循环依赖
+}
+
+/**
+ * 注册一个可按类型注入的单例组件。component可以是组件实例，也可以是构造函数：
+ * func(...) T 或 func(...) (T, error)，构造函数的参数按类型从已注册组件中获取，构造函数在注册路由时被调用一次。
+ * 组件会被注入到Handler、Service、DB操作对象中类型完全一致的公开Field，或带有inject标签且类型兼容的Field
+ */
+func (this *ApiServer) Provide(component interface{}) {
+	def := newComponentDef("", component)
+	for _, existDef := range this.components {
+		if existDef.name == "" && existDef.componentType == def.componentType {
+			panic(fmt.Sprintf("component of type %s has already been provided", def.componentType.String()))
+		}
+	}
+	this.components = append(this.components, def)
+}
+
+/**
+ * 注册一个按名称注入的单例组件，只注入到带有inject:"name"标签的Field
+ */
+func (this *ApiServer) ProvideNamed(name string, component interface{}) {
+	if name == "" {
+		panic("component name is empty")
+	}
+	def := newComponentDef(name, component)
+	for _, existDef := range this.components {
+		if existDef.name == name {
+			panic(fmt.Sprintf("component named <%s> has already been provided", name))
+		}
+	}
+	this.components = append(this.components, def)
+}
+
+/**
+ * 解析组件定义，构造函数以第一个返回值的类型作为组件类型
+ */
+func newComponentDef(name string, component interface{}) *componentDef {
+	if component == nil {
+		panic("component is nil")
+	}
+	def := new(componentDef)
+	def.name = name
+	componentVal := reflect.ValueOf(component)
+	if componentVal.Kind() != reflect.Func {
+		def.componentType = componentVal.Type()
+		def.value = componentVal
+		def.constructed = true
+		return def
+	}
+	funcType := componentVal.Type()
+	if funcType.NumOut() == 0 || funcType.NumOut() > 2 || (funcType.NumOut() == 2 && funcType.Out(1) != errorType) {
+		panic(fmt.Sprintf("illegal component constructor %s, should be func(...) T or func(...) (T, error)", funcType.String()))
+	}
+	def.componentType = funcType.Out(0)
+	def.constructor = componentVal
+	return def
+}
+
+/**
+ * 按名称查找组件
+ */
+func (this *ApiServer) getNamedComponent(name string) (reflect.Value, error) {
+	for _, def := range this.components {
+		if def.name == name {
+			return this.constructComponent(def)
+		}
+	}
+	return reflect.Value{}, fmt.Errorf("component named <%s> is not provided", name)
+}
+
+/**
+ * 按类型查找未命名组件：优先类型完全一致的组件，allowAssignable为true时也可匹配唯一一个实现了该接口类型的组件。
+ * 返回的ok为false表示没有匹配的组件
+ */
+func (this *ApiServer) getComponentByType(targetType reflect.Type, allowAssignable bool) (reflect.Value, bool, error) {
+	for _, def := range this.components {
+		if def.name == "" && def.componentType == targetType {
+			value, err := this.constructComponent(def)
+			return value, true, err
+		}
+	}
+	if !allowAssignable || targetType.Kind() != reflect.Interface {
+		return reflect.Value{}, false, nil
+	}
+	var matchDefs []*componentDef
+	for _, def := range this.components {
+		if def.name == "" && def.componentType.Implements(targetType) {
+			matchDefs = append(matchDefs, def)
+		}
+	}
+	if len(matchDefs) == 0 {
+		return reflect.Value{}, false, nil
+	}
+	if len(matchDefs) > 1 {
+		return reflect.Value{}, true, fmt.Errorf("more than one component implements %s", targetType.String())
+	}
+	value, err := this.constructComponent(matchDefs[0])
+	return value, true, err
+}
+
+/**
+ * 获取组件实例，构造函数注册的组件在首次获取时构造
+ */
+func (this *ApiServer) constructComponent(def *componentDef) (reflect.Value, error) {
+	if def.constructed {
+		return def.value, nil
+	}
+	if def.constructing {
+		return reflect.Value{}, fmt.Errorf("component constructor of %s depends on itself", def.componentType.String())
+	}
+	def.constructing = true
+	defer func() {
+		def.constructing = false
+	}()
+	funcType := def.constructor.Type()
+	args := make([]reflect.Value, 0, funcType.NumIn())
+	for i := 0; i < funcType.NumIn(); i++ {
+		argVal, ok, err := this.getComponentByType(funcType.In(i), true)
+		if err != nil {
+			return reflect.Value{}, err
+		}
+		if !ok {
+			return reflect.Value{}, fmt.Errorf("component constructor of %s requires %s, which is not provided", def.componentType.String(), funcType.In(i).String())
+		}
+		args = append(args, argVal)
+	}
+	results := def.constructor.Call(args)
+	if len(results) == 2 && !results[1].IsNil() {
+		return reflect.Value{}, fmt.Errorf("construct component %s failed: %s", def.componentType.String(), results[1].Interface().(error).Error())
+	}
+	if isNilValue(results[0]) {
+		return reflect.Value{}, errors.New("component constructor of " + def.componentType.String() + " returns nil")
+	}
+	def.value = results[0]
+	def.constructed = true
+	return def.value, nil
+}
+
+/**
+ * 判断反射值是否为nil（仅对可为nil的类型有效）
+ */
+func isNilValue(value reflect.Value) bool {
+	switch value.Kind() {
+	case reflect.Ptr, reflect.Interface, reflect.Map, reflect.Slice, reflect.Func, reflect.Chan:
+		return value.IsNil()
+	}
+	return false
+}
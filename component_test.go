@@ -0,0 +1,98 @@
+package simpleapi
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+type componentTestCache interface {
+	Get(key string) string
+}
+
+type componentTestMemCache struct {
+	prefix string
+}
+
+func (this *componentTestMemCache) Get(key string) string {
+	return this.prefix + key
+}
+
+type componentTestClient struct {
+	Timeout time.Duration
+}
+
+type componentTestRedisConf struct {
+	Addr string `json:"addr"`
+	Db   int    `json:"db"`
+}
+
+type componentTestDb struct {
+	Client *componentTestClient
+	BaseDbOperator
+}
+
+type componentTestService struct {
+	Cache     componentTestCache      `inject:""`
+	Primary   componentTestCache      `inject:"primary"`
+	PageSize  int                     `config:"page_size"`
+	RedisConf *componentTestRedisConf `config:"redis"`
+	DB        *componentTestDb
+	BaseService
+}
+
+type componentTestHandler struct {
+	Service *componentTestService
+	Client  *componentTestClient
+	Timeout time.Duration `config:"timeout"`
+	BaseHandler
+}
+
+func (this *componentTestHandler) HandleRequest(r *Request) (interface{}, error) {
+	return nil, nil
+}
+
+func TestProvideComponentAndConfig(t *testing.T) {
+	s := new(ApiServer)
+	s.Init()
+	s.Provide(&componentTestMemCache{prefix: "mem:"})
+	s.ProvideNamed("primary", func(cache *componentTestMemCache) componentTestCache {
+		return &componentTestMemCache{prefix: "primary:" + cache.prefix}
+	})
+	s.Provide(func() (*componentTestClient, error) {
+		return &componentTestClient{Timeout: time.Second}, nil
+	})
+	s.SetConfig("page_size", "20")
+	s.SetConfig("timeout", "3s")
+	s.SetConfig("redis", map[string]interface{}{"addr": "127.0.0.1:6379", "db": 2})
+	plan, err := s.buildHandlerInjectPlan(reflect.TypeOf(componentTestHandler{}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := new(RequestContext)
+	ctx.Init()
+	handlerVal := reflect.New(plan.handlerType)
+	assembleFixedFields(handlerVal.Elem(), plan.fixedFields)
+	s.assembleServiceToHandler(handlerVal, plan, newServiceScope(ctx))
+	handler := handlerVal.Interface().(*componentTestHandler)
+	if handler.Timeout != 3*time.Second || handler.Client == nil || handler.Service.DB.Client != handler.Client {
+		t.Fatal("component or config is not injected into handler and db operator")
+	}
+	service := handler.Service
+	if service.Cache.Get("k") != "mem:k" || service.Primary.Get("k") != "primary:mem:k" {
+		t.Fatal("component is not injected into service")
+	}
+	if service.PageSize != 20 || service.RedisConf.Addr != "127.0.0.1:6379" || service.RedisConf.Db != 2 {
+		t.Fatalf("config is not injected into service: %d %+v", service.PageSize, service.RedisConf)
+	}
+}
+
+func TestUnsatisfiedComponent(t *testing.T) {
+	s := new(ApiServer)
+	s.Init()
+	_, err := s.buildHandlerInjectPlan(reflect.TypeOf(componentTestHandler{}))
+	if err == nil {
+		t.Fatal("missing component and config should be reported")
+	}
+	t.Log(err)
+}
@@ -0,0 +1,144 @@
+package simpleapi
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var durationType = reflect.TypeOf(time.Duration(0))
+
+/**
+ * 设置一项服务器配置，配置会被注入到Handler、Service、DB操作对象中带有config:"key"标签的Field
+ */
+func (this *ApiServer) SetConfig(key string, value interface{}) {
+	if this.configs == nil {
+		this.configs = make(map[string]interface{})
+	}
+	this.configs[key] = value
+}
+
+/**
+ * 批量设置服务器配置
+ */
+func (this *ApiServer) LoadConfig(configs map[string]interface{}) {
+	for key, value := range configs {
+		this.SetConfig(key, value)
+	}
+}
+
+/**
+ * 获取一项服务器配置
+ */
+func (this *ApiServer) GetConfig(key string) (interface{}, bool) {
+	value, ok := this.configs[key]
+	return value, ok
+}
+
+/**
+ * 将配置值转换为Field的类型：类型兼容时直接赋值，字符串按Field类型解析，Map等结构化配置通过JSON转换为结构体
+ */
+func convertConfigValue(value interface{}, targetType reflect.Type) (reflect.Value, error) {
+	if value == nil {
+		return reflect.Zero(targetType), nil
+	}
+	configVal := reflect.ValueOf(value)
+	if configVal.Type().AssignableTo(targetType) {
+		return configVal, nil
+	}
+	strValue, isStr := value.(string)
+	if isStr {
+		return parseConfigString(strValue, targetType)
+	}
+	if isNumberKind(configVal.Kind()) && isNumberKind(targetType.Kind()) {
+		return configVal.Convert(targetType), nil
+	}
+	//结构化的配置（例如map、slice、其它结构体）通过JSON转换到目标类型
+	data, err := json.Marshal(value)
+	if err != nil {
+		return reflect.Value{}, err
+	}
+	targetVal := reflect.New(targetType)
+	err = json.Unmarshal(data, targetVal.Interface())
+	if err != nil {
+		return reflect.Value{}, fmt.Errorf("can not convert %s to %s: %s", configVal.Type().String(), targetType.String(), err.Error())
+	}
+	return targetVal.Elem(), nil
+}
+
+/**
+ * 按目标类型解析字符串形式的配置值
+ */
+func parseConfigString(value string, targetType reflect.Type) (reflect.Value, error) {
+	targetVal := reflect.New(targetType).Elem()
+	if targetType == durationType {
+		duration, err := time.ParseDuration(value)
+		if err != nil {
+			return reflect.Value{}, err
+		}
+		targetVal.SetInt(int64(duration))
+		return targetVal, nil
+	}
+	switch targetType.Kind() {
+	case reflect.String:
+		targetVal.SetString(value)
+	case reflect.Bool:
+		boolVal, err := strconv.ParseBool(value)
+		if err != nil {
+			return reflect.Value{}, err
+		}
+		targetVal.SetBool(boolVal)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		intVal, err := strconv.ParseInt(value, 10, targetType.Bits())
+		if err != nil {
+			return reflect.Value{}, err
+		}
+		targetVal.SetInt(intVal)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		uintVal, err := strconv.ParseUint(value, 10, targetType.Bits())
+		if err != nil {
+			return reflect.Value{}, err
+		}
+		targetVal.SetUint(uintVal)
+	case reflect.Float32, reflect.Float64:
+		floatVal, err := strconv.ParseFloat(value, targetType.Bits())
+		if err != nil {
+			return reflect.Value{}, err
+		}
+		targetVal.SetFloat(floatVal)
+	case reflect.Slice:
+		//字符串切片类配置以逗号分隔
+		items := strings.Split(value, ",")
+		sliceVal := reflect.MakeSlice(targetType, 0, len(items))
+		for _, item := range items {
+			itemVal, err := parseConfigString(strings.TrimSpace(item), targetType.Elem())
+			if err != nil {
+				return reflect.Value{}, err
+			}
+			sliceVal = reflect.Append(sliceVal, itemVal)
+		}
+		return sliceVal, nil
+	default:
+		err := json.Unmarshal([]byte(value), targetVal.Addr().Interface())
+		if err != nil {
+			return reflect.Value{}, fmt.Errorf("can not convert string to %s: %s", targetType.String(), err.Error())
+		}
+	}
+	return targetVal, nil
+}
+
+/**
+ * 判断是否为数值类型
+ */
+func isNumberKind(kind reflect.Kind) bool {
+	switch kind {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		return true
+	}
+	return false
+}
@@ -5,3 +5,39 @@ const (
 	HTTP_HEADER_REQ_IDENTIFIER = "Req-Id"
 	HTTP_HEADER_CLIENT_FALG    = "Client-Flag"
 )
+
+/**
+ * Service的注入作用域，可通过Field标签`scope:"singleton"`或ApiServer.SetServiceScope()指定
+ */
+const (
+	SERVICE_SCOPE_TAG       = "scope"
+	SERVICE_SCOPE_REQUEST   = "request"   //同一请求内共享一个实例（默认）
+	SERVICE_SCOPE_SINGLETON = "singleton" //整个ApiServer共享一个实例
+	SERVICE_SCOPE_TRANSIENT = "transient" //每个Field都新建一个实例
+)
+
+/**
+ * 组件及配置注入使用的Field标签
+ */
+const (
+	COMPONENT_INJECT_TAG = "inject" //inject:"name"按名称注入，inject:""按类型注入
+	CONFIG_INJECT_TAG    = "config" //config:"key"注入服务器配置
+)
+
+/**
+ * 数据源选择，可通过Field标签`datasource:"report"`或实现IDataSourceSelector接口指定Service及DB操作对象使用的数据源
+ */
+const (
+	DATA_SOURCE_TAG     = "datasource"
+	DEFAULT_DATA_SOURCE = "default" //OpenMySQLOrmConn()打开的全局数据库连接
+)
+
+/**
+ * Service事务的传播方式，用于BaseService.BeginTransactionWith()及InTransactionWith()
+ */
+const (
+	TX_PROPAGATION_REQUIRED     = "REQUIRED"     //当前有事务则加入，否则开启新事务
+	TX_PROPAGATION_REQUIRES_NEW = "REQUIRES_NEW" //始终开启独立的新事务，外层事务在此期间挂起
+	TX_PROPAGATION_NESTED       = "NESTED"       //当前有事务则通过保存点嵌套，否则开启新事务
+	TX_PROPAGATION_SUPPORTS     = "SUPPORTS"     //当前有事务则加入，否则以非事务方式执行
+)
@@ -1,8 +1,11 @@
 package simpleapi
 
 import (
-	"github.com/google/uuid"
+	"context"
 	"sync"
+
+	"github.com/duhaifeng/simpleapi/db"
+	"github.com/google/uuid"
 )
 
 /**
@@ -15,6 +18,17 @@ type RequestContext struct {
 	clientIp      string
 	clientFlag    string
 	ctxAttachment *sync.Map
+	txStack       []*ctxTx //本次请求中正在生效的事务连接，栈顶为最内层事务，Service间通过它传播事务
+	forcePrimary  bool     //本次请求的读操作强制使用主库
+	sqlLogMode    int      //本次请求的SQL日志开关，见db.SQL_LOG_*定义
+}
+
+/**
+ * 请求中生效的一个事务连接及其所属的数据源
+ */
+type ctxTx struct {
+	dataSource string
+	tx         *db.GormProxy
 }
 
 /**
@@ -103,3 +117,66 @@ func (this *RequestContext) GetAttachment(key string) (interface{}, bool) {
 func (this *RequestContext) RmAttachment(key string) {
 	this.ctxAttachment.Delete(key)
 }
+
+/**
+ * 设置本次请求的读操作是否强制使用主库，用于写入后需要立即读到最新数据（read-your-writes）的请求
+ */
+func (this *RequestContext) SetForcePrimary(forcePrimary bool) {
+	this.forcePrimary = forcePrimary
+}
+
+/**
+ * 判断本次请求的读操作是否强制使用主库
+ */
+func (this *RequestContext) IsForcePrimary() bool {
+	return this.forcePrimary
+}
+
+/**
+ * 开启或关闭本次请求的SQL日志：开启时记录所有语句，关闭时不记录语句及慢查询（执行出错的语句仍然记录）
+ */
+func (this *RequestContext) SetSqlLog(enabled bool) {
+	if enabled {
+		this.sqlLogMode = db.SQL_LOG_ON
+	} else {
+		this.sqlLogMode = db.SQL_LOG_OFF
+	}
+}
+
+/**
+ * 获取携带本次请求ID及SQL日志开关的context，通过gorm的WithContext使用后，SQL日志以请求ID作为前缀
+ */
+func (this *RequestContext) SqlContext() context.Context {
+	return db.WithSqlLog(context.Background(), this.reqId, this.sqlLogMode)
+}
+
+/**
+ * 将新开启的事务连接压入请求的事务栈，使之后调用的Service及DB操作对象加入该事务
+ */
+func (this *RequestContext) pushTx(dataSource string, tx *db.GormProxy) {
+	this.txStack = append(this.txStack, &ctxTx{dataSource: dataSource, tx: tx})
+}
+
+/**
+ * 将结束的事务连接从请求的事务栈中移除
+ */
+func (this *RequestContext) popTx(tx *db.GormProxy) {
+	for i := len(this.txStack) - 1; i >= 0; i-- {
+		if this.txStack[i].tx == tx {
+			this.txStack = append(this.txStack[:i], this.txStack[i+1:]...)
+			return
+		}
+	}
+}
+
+/**
+ * 获取请求中指定数据源当前生效的事务连接，没有事务时返回nil
+ */
+func (this *RequestContext) currentTx(dataSource string) *db.GormProxy {
+	for i := len(this.txStack) - 1; i >= 0; i-- {
+		if this.txStack[i].dataSource == dataSource {
+			return this.txStack[i].tx
+		}
+	}
+	return nil
+}
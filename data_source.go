@@ -0,0 +1,165 @@
+package simpleapi
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/duhaifeng/simpleapi/db"
+)
+
+/**
+ * Service或DB操作对象通过实现本接口（重写DataSource方法）选择使用的数据源，Field上的datasource标签优先于本接口
+ */
+type IDataSourceSelector interface {
+	DataSource() string
+}
+
+var dataSourceSelectorType = reflect.TypeOf((*IDataSourceSelector)(nil)).Elem()
+
+/**
+ * 注册一个命名数据源，Service及DB操作对象可以通过名称选择使用该数据源
+ */
+func (this *ApiServer) RegisterDataSource(name string, conn *db.GormProxy) {
+	if name == "" {
+		name = DEFAULT_DATA_SOURCE
+	}
+	if this.dataSources == nil {
+		this.dataSources = make(map[string]*db.GormProxy)
+	}
+	this.dataSources[name] = conn
+	if name == DEFAULT_DATA_SOURCE {
+		this.ormConn = conn
+	}
+}
+
+/**
+ * 打开一个MySQL命名数据源
+ */
+func (this *ApiServer) OpenMySQLDataSource(name, host, port, user, pass, database string) error {
+	return this.OpenMySQLDataSourceWithOptions(name, host, port, user, pass, database, nil)
+}
+
+/**
+ * 按连接选项（DSN参数及连接池配置）打开一个MySQL命名数据源，options为nil时使用默认选项
+ */
+func (this *ApiServer) OpenMySQLDataSourceWithOptions(name, host, port, user, pass, database string, options *db.ConnOptions) error {
+	return this.OpenDataSourceWithOptions(name, db.DIALECT_MYSQL, host, port, user, pass, database, options)
+}
+
+/**
+ * 按方言（mysql、postgres、sqlite等）及连接选项打开一个命名数据源，options为nil时使用默认选项
+ */
+func (this *ApiServer) OpenDataSourceWithOptions(name, dialect, hostOrPath, port, user, pass, database string, options *db.ConnOptions) error {
+	conn := new(db.GormProxy)
+	err := conn.OpenWithOptions(dialect, hostOrPath, port, user, pass, database, options)
+	if err != nil {
+		return err
+	}
+	this.RegisterDataSource(name, conn)
+	return nil
+}
+
+/**
+ * 为命名数据源打开一个MySQL只读从库，事务外的读操作（BaseDbOperator.ReadOrmConn）会被路由到健康的从库，
+ * 从库的健康检查随第一个从库自动启动，在关闭数据源时停止
+ */
+func (this *ApiServer) OpenMySQLReplica(dataSource, host, port, user, pass, database string) error {
+	conn := this.GetDataSource(dataSource)
+	if conn == nil {
+		return fmt.Errorf("data source <%s> is not registered", dataSource)
+	}
+	return conn.OpenMySQLReplica(host, port, user, pass, database)
+}
+
+/**
+ * 获取命名数据源的连接，未注册时返回nil
+ */
+func (this *ApiServer) GetDataSource(name string) *db.GormProxy {
+	if name == "" || name == DEFAULT_DATA_SOURCE {
+		return this.ormConn
+	}
+	return this.dataSources[name]
+}
+
+/**
+ * 检查所有数据源的连接是否可用，返回数据源名称到检查结果的映射
+ */
+func (this *ApiServer) PingDataSources(ctx context.Context) map[string]error {
+	results := make(map[string]error)
+	for name, conn := range this.allDataSources() {
+		results[name] = conn.PingContext(ctx)
+	}
+	return results
+}
+
+/**
+ * 关闭所有数据源的连接
+ */
+func (this *ApiServer) CloseDataSources() error {
+	var errs []string
+	for name, conn := range this.allDataSources() {
+		err := conn.Close()
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("%s: %s", name, err.Error()))
+			continue
+		}
+		logger.Info("data source <%s> closed", name)
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("close data sources failed: %s", strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+/**
+ * 获取所有已打开的数据源（包括直接设置的默认数据源）
+ */
+func (this *ApiServer) allDataSources() map[string]*db.GormProxy {
+	dataSources := make(map[string]*db.GormProxy)
+	for name, conn := range this.dataSources {
+		if conn != nil {
+			dataSources[name] = conn
+		}
+	}
+	if this.ormConn != nil {
+		dataSources[DEFAULT_DATA_SOURCE] = this.ormConn
+	}
+	return dataSources
+}
+
+/**
+ * 判断数据源是否可用，默认数据源总是可用（未打开数据库时Service使用nil连接，与之前的行为保持一致）
+ */
+func (this *ApiServer) hasDataSource(name string) bool {
+	if name == DEFAULT_DATA_SOURCE {
+		return true
+	}
+	_, ok := this.dataSources[name]
+	return ok
+}
+
+/**
+ * 确定结构体类型使用的数据源：Field标签优先于IDataSourceSelector接口，返回空字符串表示未指定
+ */
+func getDataSourceName(structType reflect.Type, fieldTag reflect.StructTag) string {
+	tagDataSource := fieldTag.Get(DATA_SOURCE_TAG)
+	if tagDataSource != "" {
+		return tagDataSource
+	}
+	if reflect.PtrTo(structType).Implements(dataSourceSelectorType) {
+		return reflect.New(structType).Interface().(IDataSourceSelector).DataSource()
+	}
+	return ""
+}
+
+/**
+ * 检查数据源是否已注册，未注册时返回错误
+ */
+func (this *ApiServer) checkDataSource(name string) error {
+	if !this.hasDataSource(name) {
+		return fmt.Errorf("data source <%s> is not registered", name)
+	}
+	return nil
+}
@@ -0,0 +1,110 @@
+package simpleapi
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/duhaifeng/simpleapi/db"
+	_ "github.com/duhaifeng/simpleapi/db/sqlite"
+	"gorm.io/gorm"
+)
+
+type dataSourceTestReportDb struct {
+	BaseDbOperator
+}
+
+type dataSourceTestOrderService struct {
+	OrderDb  *dataSourceTestReportDb
+	ReportDb *dataSourceTestReportDb `datasource:"report"`
+	BaseService
+}
+
+type dataSourceTestReportService struct {
+	Helper *dataSourceTestHelperService
+	BaseService
+}
+
+func (this *dataSourceTestReportService) DataSource() string {
+	return "report"
+}
+
+type dataSourceTestHelperService struct {
+	BaseService
+}
+
+type dataSourceTestHandler struct {
+	Order        *dataSourceTestOrderService
+	Report       *dataSourceTestReportService
+	ArchiveOrder *dataSourceTestOrderService `datasource:"archive"`
+	BaseHandler
+}
+
+func (this *dataSourceTestHandler) HandleRequest(r *Request) (interface{}, error) {
+	return nil, nil
+}
+
+/**
+ * 创建一个测试用的SQLite内存数据源，各数据源使用不同的连接池以便区分，测试结束时关闭
+ */
+func newTestDataSource(t *testing.T) *db.GormProxy {
+	conn := new(db.GormProxy)
+	err := conn.OpenSqlite(":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		conn.Close()
+	})
+	return conn
+}
+
+/**
+ * 判断DB操作对象的连接（附加了请求上下文的会话）是否来自该数据源
+ */
+func isSameConn(conn *gorm.DB, dataSource *db.GormProxy) bool {
+	return conn.Statement.ConnPool == dataSource.Conn.Statement.ConnPool
+}
+
+func TestNamedDataSources(t *testing.T) {
+	s := new(ApiServer)
+	s.Init()
+	orderConn := newTestDataSource(t)
+	reportConn := newTestDataSource(t)
+	archiveConn := newTestDataSource(t)
+	s.RegisterDataSource(DEFAULT_DATA_SOURCE, orderConn)
+	s.RegisterDataSource("report", reportConn)
+	s.RegisterDataSource("archive", archiveConn)
+	plan, err := s.buildHandlerInjectPlan(reflect.TypeOf(dataSourceTestHandler{}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := new(RequestContext)
+	ctx.Init()
+	handler := s.assembleServiceToHandler(reflect.New(plan.handlerType), plan, newServiceScope(ctx)).Interface().(*dataSourceTestHandler)
+	if handler.Order.GetOrmConn() != orderConn || !isSameConn(handler.Order.OrderDb.OrmConn(), orderConn) {
+		t.Fatal("order service should use the default data source")
+	}
+	if !isSameConn(handler.Order.ReportDb.OrmConn(), reportConn) {
+		t.Fatal("db operator tag should select the report data source")
+	}
+	sqlConn, err := handler.Order.ReportDb.SqlConn()
+	if err != nil || sqlConn.DB() != reportConn.Conn.Statement.ConnPool {
+		t.Fatalf("raw sql conn should share the db operator's data source: %v", err)
+	}
+	if handler.Report.GetOrmConn() != reportConn || handler.Report.Helper.GetOrmConn() != reportConn {
+		t.Fatal("DataSource() should select the report data source and be inherited by referenced services")
+	}
+	if handler.ArchiveOrder == handler.Order || handler.ArchiveOrder.GetOrmConn() != archiveConn {
+		t.Fatal("same service type on another data source should be a separate instance")
+	}
+}
+
+func TestUnregisteredDataSource(t *testing.T) {
+	s := new(ApiServer)
+	s.Init()
+	s.RegisterDataSource("report", &db.GormProxy{})
+	_, err := s.buildHandlerInjectPlan(reflect.TypeOf(dataSourceTestHandler{}))
+	if err == nil {
+		t.Fatal("unregistered archive data source should be reported")
+	}
+}
@@ -0,0 +1,179 @@
+package db
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"sync"
+
+	"gorm.io/gorm/clause"
+	"gorm.io/gorm/schema"
+)
+
+/**
+ * 批量写入时每批的默认记录数
+ */
+const DEFAULT_BATCH_SIZE = 500
+
+/**
+ * 批量写入选项
+ */
+type BatchOptions struct {
+	BatchSize       int      //每批写入的记录数，为0时使用DEFAULT_BATCH_SIZE，超过数据库单条SQL的占位符上限时自动减小
+	Upsert          bool     //记录冲突时更新已有记录（MySQL为ON DUPLICATE KEY UPDATE，PostgreSQL及SQLite为ON CONFLICT）
+	ConflictColumns []string //判断冲突的唯一键列，为空时使用主键（MySQL按表上的唯一索引判断，忽略本项）
+	UpdateColumns   []string //冲突时更新的列，为空时更新除主键外的所有列
+}
+
+/**
+ * 批量操作及分页查询解析的Schema缓存
+ */
+var schemaCache = new(sync.Map)
+
+/**
+ * 向数据库中批量插入数据，每批DEFAULT_BATCH_SIZE条。列名按gorm的Schema解析（支持column标签、"-"忽略及嵌入结构体），
+ * 未赋值的自增主键不会被写入，写入后回填到数据中
+ */
+func (this *GormProxy) BatchInsert(data interface{}) error {
+	return this.BatchInsertWith(data, nil)
+}
+
+/**
+ * 批量插入数据，记录冲突时更新updateColumns指定的列（为空时更新除主键外的所有列）
+ */
+func (this *GormProxy) BatchUpsert(data interface{}, conflictColumns []string, updateColumns ...string) error {
+	return this.BatchInsertWith(data, &BatchOptions{Upsert: true, ConflictColumns: conflictColumns, UpdateColumns: updateColumns})
+}
+
+/**
+ * 按批量写入选项插入数据，data需要是结构体或结构体指针的切片。数据不合法时返回错误，写入失败时返回数据库错误，
+ * 多个批次在同一个事务中写入（GormProxy已在事务中时加入该事务）
+ */
+func (this *GormProxy) BatchInsertWith(data interface{}, options *BatchOptions) error {
+	if this.Conn == nil {
+		return errors.New("gorm: batch insert failed. db conn is nil")
+	}
+	if options == nil {
+		options = new(BatchOptions)
+	}
+	dataVal, modelSchema, err := this.parseBatchData(data)
+	if err != nil {
+		return err
+	}
+	if dataVal.Len() == 0 {
+		return nil
+	}
+	batchSize := this.limitBatchSize(options.BatchSize, len(modelSchema.DBNames))
+	conn := this.Conn
+	if options.Upsert {
+		onConflict, err := buildOnConflict(modelSchema, options)
+		if err != nil {
+			return err
+		}
+		conn = conn.Clauses(onConflict)
+	}
+	err = conn.CreateInBatches(data, batchSize).Error
+	if err != nil {
+		return fmt.Errorf("gorm: write data to database failed. %w", err)
+	}
+	return nil
+}
+
+/**
+ * 校验批量数据并解析其Schema：data需要是同一结构体类型（或其指针）的切片，指针元素不能为nil
+ */
+func (this *GormProxy) parseBatchData(data interface{}) (reflect.Value, *schema.Schema, error) {
+	if data == nil {
+		return reflect.Value{}, nil, errors.New("gorm: batch data is nil")
+	}
+	dataVal := reflect.Indirect(reflect.ValueOf(data))
+	if dataVal.Kind() != reflect.Slice && dataVal.Kind() != reflect.Array {
+		return reflect.Value{}, nil, fmt.Errorf("gorm: batch data [type kind:%s] is not a slice", dataVal.Kind())
+	}
+	elemType := dataVal.Type().Elem()
+	isPtr := elemType.Kind() == reflect.Ptr
+	if isPtr {
+		elemType = elemType.Elem()
+	}
+	if elemType.Kind() != reflect.Struct {
+		return reflect.Value{}, nil, fmt.Errorf("gorm: batch data element [%s] must be struct or struct ptr", dataVal.Type().Elem().String())
+	}
+	if isPtr {
+		for i := 0; i < dataVal.Len(); i++ {
+			if dataVal.Index(i).IsNil() {
+				return reflect.Value{}, nil, fmt.Errorf("gorm: batch data element %d is nil", i)
+			}
+		}
+	}
+	modelSchema, err := this.parseSchema(elemType)
+	if err != nil {
+		return reflect.Value{}, nil, err
+	}
+	return dataVal, modelSchema, nil
+}
+
+/**
+ * 解析结构体类型的Schema
+ */
+func (this *GormProxy) parseSchema(modelType reflect.Type) (*schema.Schema, error) {
+	return parseModelSchema(this.Conn, modelType)
+}
+
+/**
+ * 根据每行记录占用的占位符数限制每批的记录数，使单条SQL的占位符数不超过数据库的上限
+ */
+func (this *GormProxy) limitBatchSize(batchSize, placeholdersPerRow int) int {
+	if batchSize <= 0 {
+		batchSize = DEFAULT_BATCH_SIZE
+	}
+	if placeholdersPerRow <= 0 {
+		return batchSize
+	}
+	maxRows := this.maxPlaceholders() / placeholdersPerRow
+	if maxRows < 1 {
+		maxRows = 1
+	}
+	if batchSize > maxRows {
+		return maxRows
+	}
+	return batchSize
+}
+
+/**
+ * 生成冲突时更新的子句，ConflictColumns及UpdateColumns可以是字段名或列名
+ */
+func buildOnConflict(modelSchema *schema.Schema, options *BatchOptions) (clause.OnConflict, error) {
+	onConflict := clause.OnConflict{}
+	conflictColumns, err := lookUpColumns(modelSchema, options.ConflictColumns)
+	if err != nil {
+		return onConflict, err
+	}
+	for _, column := range conflictColumns {
+		onConflict.Columns = append(onConflict.Columns, clause.Column{Name: column})
+	}
+	if len(options.UpdateColumns) == 0 {
+		onConflict.UpdateAll = true
+		return onConflict, nil
+	}
+	updateColumns, err := lookUpColumns(modelSchema, options.UpdateColumns)
+	if err != nil {
+		return onConflict, err
+	}
+	onConflict.DoUpdates = clause.AssignmentColumns(updateColumns)
+	return onConflict, nil
+}
+
+/**
+ * 将字段名或列名转换为Schema中的列名，不存在的列返回错误
+ */
+func lookUpColumns(modelSchema *schema.Schema, names []string) ([]string, error) {
+	columns := make([]string, 0, len(names))
+	for _, name := range names {
+		field := modelSchema.LookUpField(name)
+		if field == nil || field.DBName == "" {
+			return nil, fmt.Errorf("gorm: column %s is not found in %s", name, modelSchema.Name)
+		}
+		columns = append(columns, field.DBName)
+	}
+	return columns, nil
+}
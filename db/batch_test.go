@@ -0,0 +1,101 @@
+package db
+
+import (
+	"testing"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"gorm.io/gorm/schema"
+)
+
+type BatchTestBase struct {
+	CreateTime int64
+	Creator    string `gorm:"column:created_by"`
+}
+
+type batchTestOrder struct {
+	Id      int64
+	OrderNo string `gorm:"uniqueIndex"`
+	Amount  int
+	Memo    string `gorm:"-"`
+	BatchTestBase
+}
+
+func newBatchTestProxy() *GormProxy {
+	return &GormProxy{Conn: &gorm.DB{Config: &gorm.Config{NamingStrategy: schema.NamingStrategy{}}}}
+}
+
+func TestBatchDataValidation(t *testing.T) {
+	proxy := newBatchTestProxy()
+	invalidData := []interface{}{nil, batchTestOrder{}, []int{1}, []*batchTestOrder{{}, nil}}
+	for _, data := range invalidData {
+		if proxy.BatchInsert(data) == nil {
+			t.Errorf("batch insert %#v should fail", data)
+		}
+	}
+	if proxy.BatchInsert([]batchTestOrder{}) != nil {
+		t.Error("empty batch should be ignored")
+	}
+	if new(GormProxy).BatchInsert([]batchTestOrder{{}}) == nil {
+		t.Error("batch insert without conn should fail")
+	}
+}
+
+func TestBatchSchemaColumns(t *testing.T) {
+	proxy := newBatchTestProxy()
+	_, modelSchema, err := proxy.parseBatchData([]*batchTestOrder{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if modelSchema.LookUpField("Memo").DBName != "" || modelSchema.LookUpField("created_by") == nil || modelSchema.Table != "batch_test_orders" {
+		t.Fatal("schema should honour gorm tags and embedded structs")
+	}
+	if !modelSchema.PrioritizedPrimaryField.AutoIncrement {
+		t.Fatal("integer Id should be an auto-increment key")
+	}
+	onConflict, err := buildOnConflict(modelSchema, &BatchOptions{ConflictColumns: []string{"OrderNo"}, UpdateColumns: []string{"Amount", "created_by"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(onConflict.Columns) != 1 || onConflict.Columns[0].Name != "order_no" || len(onConflict.DoUpdates) != 2 ||
+		onConflict.DoUpdates[1].Column != (clause.Column{Name: "created_by"}) {
+		t.Fatalf("unexpected on conflict clause %#v", onConflict)
+	}
+	_, err = buildOnConflict(modelSchema, &BatchOptions{UpdateColumns: []string{"Memo"}})
+	if err == nil {
+		t.Fatal("ignored field should not be updated")
+	}
+}
+
+func TestBatchUpdateFields(t *testing.T) {
+	proxy := newBatchTestProxy()
+	_, modelSchema, _ := proxy.parseBatchData([]batchTestOrder{})
+	keyField, err := lookUpKeyField(modelSchema, "")
+	if err != nil || keyField.DBName != "id" {
+		t.Fatalf("primary key should be the default key: %v", err)
+	}
+	keyField, _ = lookUpKeyField(modelSchema, "OrderNo")
+	fields, err := lookUpUpdateFields(modelSchema, keyField, nil)
+	if err != nil || len(fields) != 3 || fields[0].DBName != "amount" {
+		t.Fatalf("all columns except keys should be updated: %v %d", err, len(fields))
+	}
+	if _, err = lookUpUpdateFields(modelSchema, keyField, []string{"order_no"}); err == nil {
+		t.Fatal("key column should not be updated")
+	}
+	if _, err = lookUpKeyField(modelSchema, "Memo"); err == nil {
+		t.Fatal("ignored field should not be a key")
+	}
+}
+
+func TestLimitBatchSize(t *testing.T) {
+	proxy := newBatchTestProxy()
+	if proxy.limitBatchSize(0, 1) != DEFAULT_BATCH_SIZE {
+		t.Fatal("default batch size should be used")
+	}
+	if proxy.limitBatchSize(1000, 3) != DEFAULT_MAX_PLACEHOLDERS/3 {
+		t.Fatal("batch size should be limited by placeholders")
+	}
+	if proxy.limitBatchSize(10, DEFAULT_MAX_PLACEHOLDERS+1) != 1 {
+		t.Fatal("batch size should be at least 1")
+	}
+}
@@ -0,0 +1,218 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"gorm.io/gorm/schema"
+)
+
+/**
+ * 批量更新或删除的结果，影响行数为数据库报告的行数（MySQL默认不计入值没有变化的行）
+ */
+type BatchResult struct {
+	BatchRows    []int64 //每批影响的行数
+	RowsAffected int64   //影响的总行数
+}
+
+/**
+ * 记录一批的影响行数
+ */
+func (this *BatchResult) add(rows int64) {
+	this.BatchRows = append(this.BatchRows, rows)
+	this.RowsAffected += rows
+}
+
+/**
+ * 按键列批量更新数据，每条记录更新为各自的值，updateColumns为空时更新除主键及键列外的所有列
+ */
+func (this *GormProxy) BatchUpdate(data interface{}, keyColumn string, updateColumns ...string) (*BatchResult, error) {
+	return this.BatchUpdateWith(data, keyColumn, &BatchOptions{UpdateColumns: updateColumns})
+}
+
+/**
+ * 按批量选项更新数据：data为结构体或结构体指针的切片，keyColumn为定位记录的列（字段名或列名，为空时使用主键），
+ * 每批生成一条 UPDATE ... SET col = CASE key WHEN ? THEN ? ... END WHERE key IN (...) 语句，
+ * 同一批中键重复时以第一条记录为准。所有批次在同一个事务中执行（已在事务中时通过保存点执行），任意一批失败时全部回滚
+ */
+func (this *GormProxy) BatchUpdateWith(data interface{}, keyColumn string, options *BatchOptions) (*BatchResult, error) {
+	if this.Conn == nil {
+		return nil, errors.New("gorm: batch update failed. db conn is nil")
+	}
+	if options == nil {
+		options = new(BatchOptions)
+	}
+	dataVal, modelSchema, err := this.parseBatchData(data)
+	if err != nil {
+		return nil, err
+	}
+	keyField, err := lookUpKeyField(modelSchema, keyColumn)
+	if err != nil {
+		return nil, err
+	}
+	updateFields, err := lookUpUpdateFields(modelSchema, keyField, options.UpdateColumns)
+	if err != nil {
+		return nil, err
+	}
+	result := new(BatchResult)
+	if dataVal.Len() == 0 {
+		return result, nil
+	}
+	//每行记录在每个CASE中占用两个占位符，在IN中占用一个
+	batchSize := this.limitBatchSize(options.BatchSize, 2*len(updateFields)+1)
+	model := reflect.New(modelSchema.ModelType).Interface()
+	ctx := context.Background()
+	err = this.runInTx("", func(tx *GormProxy) error {
+		for start := 0; start < dataVal.Len(); start += batchSize {
+			end := start + batchSize
+			if end > dataVal.Len() {
+				end = dataVal.Len()
+			}
+			keys := make([]interface{}, 0, end-start)
+			caseVars := make([][]interface{}, len(updateFields))
+			for i := start; i < end; i++ {
+				elem := reflect.Indirect(dataVal.Index(i))
+				key, _ := keyField.ValueOf(ctx, elem)
+				keys = append(keys, key)
+				for j, field := range updateFields {
+					value, _ := field.ValueOf(ctx, elem)
+					caseVars[j] = append(caseVars[j], key, value)
+				}
+			}
+			//ELSE保留原值，同时使PostgreSQL能够根据列推断CASE的类型
+			caseSQL := "CASE ?" + strings.Repeat(" WHEN ? THEN ?", end-start) + " ELSE ? END"
+			updates := make(map[string]interface{}, len(updateFields))
+			for j, field := range updateFields {
+				vars := make([]interface{}, 0, len(caseVars[j])+2)
+				vars = append(vars, clause.Column{Name: keyField.DBName})
+				vars = append(vars, caseVars[j]...)
+				vars = append(vars, clause.Column{Name: field.DBName})
+				updates[field.DBName] = gorm.Expr(caseSQL, vars...)
+			}
+			db := tx.Conn.Model(model).Where(clause.IN{Column: clause.Column{Name: keyField.DBName}, Values: keys}).UpdateColumns(updates)
+			if db.Error != nil {
+				return fmt.Errorf("gorm: batch update rows [%d, %d) failed. %w", start, end, db.Error)
+			}
+			result.add(db.RowsAffected)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+/**
+ * 按键列批量删除数据，model为表对应的结构体指针（带有软删除字段时执行软删除），keys为键值的切片
+ */
+func (this *GormProxy) BatchDelete(model interface{}, keyColumn string, keys interface{}) (*BatchResult, error) {
+	return this.BatchDeleteWith(model, keyColumn, keys, nil)
+}
+
+/**
+ * 按批量选项删除数据：keys按批次拆分为多条 DELETE ... WHERE key IN (...) 语句，每批的键数不超过数据库的占位符上限。
+ * keyColumn为空时使用主键，所有批次在同一个事务中执行，任意一批失败时全部回滚
+ */
+func (this *GormProxy) BatchDeleteWith(model interface{}, keyColumn string, keys interface{}, options *BatchOptions) (*BatchResult, error) {
+	if this.Conn == nil {
+		return nil, errors.New("gorm: batch delete failed. db conn is nil")
+	}
+	if options == nil {
+		options = new(BatchOptions)
+	}
+	modelType := reflect.TypeOf(model)
+	if modelType == nil || modelType.Kind() != reflect.Ptr || modelType.Elem().Kind() != reflect.Struct {
+		return nil, fmt.Errorf("gorm: batch delete model [%v] must be struct ptr", modelType)
+	}
+	modelSchema, err := this.parseSchema(modelType.Elem())
+	if err != nil {
+		return nil, err
+	}
+	keyField, err := lookUpKeyField(modelSchema, keyColumn)
+	if err != nil {
+		return nil, err
+	}
+	keysVal := reflect.ValueOf(keys)
+	if keysVal.Kind() != reflect.Slice && keysVal.Kind() != reflect.Array {
+		return nil, fmt.Errorf("gorm: batch delete keys [type kind:%s] is not a slice", keysVal.Kind())
+	}
+	result := new(BatchResult)
+	if keysVal.Len() == 0 {
+		return result, nil
+	}
+	batchSize := this.limitBatchSize(options.BatchSize, 1)
+	err = this.runInTx("", func(tx *GormProxy) error {
+		for start := 0; start < keysVal.Len(); start += batchSize {
+			end := start + batchSize
+			if end > keysVal.Len() {
+				end = keysVal.Len()
+			}
+			batchKeys := make([]interface{}, 0, end-start)
+			for i := start; i < end; i++ {
+				batchKeys = append(batchKeys, keysVal.Index(i).Interface())
+			}
+			db := tx.Conn.Where(clause.IN{Column: clause.Column{Name: keyField.DBName}, Values: batchKeys}).Delete(reflect.New(modelSchema.ModelType).Interface())
+			if db.Error != nil {
+				return fmt.Errorf("gorm: batch delete keys [%d, %d) failed. %w", start, end, db.Error)
+			}
+			result.add(db.RowsAffected)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+/**
+ * 获取定位记录的键字段，keyColumn为空时使用主键
+ */
+func lookUpKeyField(modelSchema *schema.Schema, keyColumn string) (*schema.Field, error) {
+	if keyColumn == "" {
+		if modelSchema.PrioritizedPrimaryField == nil {
+			return nil, fmt.Errorf("gorm: %s has no primary key", modelSchema.Name)
+		}
+		return modelSchema.PrioritizedPrimaryField, nil
+	}
+	keyField := modelSchema.LookUpField(keyColumn)
+	if keyField == nil || keyField.DBName == "" {
+		return nil, fmt.Errorf("gorm: column %s is not found in %s", keyColumn, modelSchema.Name)
+	}
+	return keyField, nil
+}
+
+/**
+ * 获取批量更新的字段，names为空时使用除主键及键列外所有可更新的字段
+ */
+func lookUpUpdateFields(modelSchema *schema.Schema, keyField *schema.Field, names []string) ([]*schema.Field, error) {
+	var fields []*schema.Field
+	if len(names) == 0 {
+		for _, field := range modelSchema.Fields {
+			if field.DBName != "" && field.Updatable && !field.PrimaryKey && field != keyField {
+				fields = append(fields, field)
+			}
+		}
+	} else {
+		for _, name := range names {
+			field := modelSchema.LookUpField(name)
+			if field == nil || field.DBName == "" {
+				return nil, fmt.Errorf("gorm: column %s is not found in %s", name, modelSchema.Name)
+			}
+			if !field.Updatable || field == keyField {
+				return nil, fmt.Errorf("gorm: column %s of %s can not be updated", name, modelSchema.Name)
+			}
+			fields = append(fields, field)
+		}
+	}
+	if len(fields) == 0 {
+		return nil, fmt.Errorf("gorm: %s has no column to update", modelSchema.Name)
+	}
+	return fields, nil
+}
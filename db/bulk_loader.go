@@ -0,0 +1,356 @@
+package db
+
+import (
+	"bytes"
+	"context"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+	"gorm.io/gorm/schema"
+)
+
+/**
+ * 流式导入的数据源，每次调用返回一条记录（结构体或结构体指针，所有记录的类型需要一致），数据读完时返回io.EOF
+ */
+type BulkSource func() (interface{}, error)
+
+/**
+ * 将通道包装为流式导入的数据源，通道关闭时数据读完。ch需要是元素为结构体或结构体指针的可接收通道
+ */
+func ChanSource(ch interface{}) BulkSource {
+	chVal := reflect.ValueOf(ch)
+	if chVal.Kind() != reflect.Chan || chVal.Type().ChanDir()&reflect.RecvDir == 0 {
+		return func() (interface{}, error) {
+			return nil, fmt.Errorf("gorm: bulk source [%T] is not a receivable chan", ch)
+		}
+	}
+	return func() (interface{}, error) {
+		row, ok := chVal.Recv()
+		if !ok {
+			return nil, io.EOF
+		}
+		return row.Interface(), nil
+	}
+}
+
+/**
+ * 流式导入选项
+ */
+type BulkLoadOptions struct {
+	BatchOptions                                 //每批的记录数及冲突时的更新方式
+	TxPerBatch   bool                            //每批在独立的事务中写入，失败时只回滚当前批，之前的批次已经提交
+	LoadData     bool                            //MySQL下使用LOAD DATA LOCAL INFILE写入（需要服务端开启local_infile），不执行gorm钩子，不写入也不回填自增主键。Upsert时以REPLACE替换冲突的记录，否则冲突的记录被跳过
+	OnProgress   func(progress BulkLoadProgress) //每写入一批后调用
+}
+
+/**
+ * 流式导入的进度
+ */
+type BulkLoadProgress struct {
+	Batches int           //已写入的批数
+	Rows    int64         //已写入的记录数
+	Elapsed time.Duration //已用时间
+}
+
+/**
+ * 从数据源流式导入数据：每读满一批即写入数据库，内存中最多保留一批记录，适用于CSV等大文件的导入。
+ * 返回已写入的进度，出错时之前写入的批次是否保留取决于TxPerBatch及调用方的事务
+ */
+func (this *GormProxy) BulkLoad(source BulkSource, options *BulkLoadOptions) (BulkLoadProgress, error) {
+	progress := BulkLoadProgress{}
+	if this.Conn == nil {
+		return progress, errors.New("gorm: bulk load failed. db conn is nil")
+	}
+	if options == nil {
+		options = new(BulkLoadOptions)
+	}
+	if options.LoadData && this.Conn.Dialector.Name() != DIALECT_MYSQL {
+		return progress, fmt.Errorf("gorm: load data is not supported by %s", this.Conn.Dialector.Name())
+	}
+	if options.LoadData && options.Upsert && (len(options.UpdateColumns) > 0 || len(options.ConflictColumns) > 0) {
+		return progress, errors.New("gorm: load data replaces whole conflicting rows and does not support conflict or update columns")
+	}
+	start := time.Now()
+	var batch reflect.Value
+	batchSize := 0
+	flush := func() error {
+		if !batch.IsValid() || batch.Len() == 0 {
+			return nil
+		}
+		err := this.writeBulkBatch(batch.Interface(), options)
+		if err != nil {
+			return fmt.Errorf("gorm: bulk load batch %d failed. %w", progress.Batches+1, err)
+		}
+		progress.Batches++
+		progress.Rows += int64(batch.Len())
+		progress.Elapsed = time.Since(start)
+		if options.OnProgress != nil {
+			options.OnProgress(progress)
+		}
+		batch = batch.Slice(0, 0)
+		return nil
+	}
+	for {
+		row, err := source()
+		if err == io.EOF {
+			return progress, flush()
+		}
+		if err != nil {
+			return progress, fmt.Errorf("gorm: read bulk source failed. %w", err)
+		}
+		rowVal := reflect.ValueOf(row)
+		if !batch.IsValid() {
+			_, modelSchema, err := this.parseBatchData(reflect.MakeSlice(reflect.SliceOf(rowVal.Type()), 0, 0).Interface())
+			if err != nil {
+				return progress, err
+			}
+			placeholdersPerRow := len(modelSchema.DBNames)
+			if options.LoadData {
+				placeholdersPerRow = 0
+			}
+			batchSize = this.limitBatchSize(options.BatchSize, placeholdersPerRow)
+			batch = reflect.MakeSlice(reflect.SliceOf(rowVal.Type()), 0, batchSize)
+		}
+		if rowVal.Type() != batch.Type().Elem() {
+			return progress, fmt.Errorf("gorm: bulk row type %s is different from %s", rowVal.Type().String(), batch.Type().Elem().String())
+		}
+		batch = reflect.Append(batch, rowVal)
+		if batch.Len() >= batchSize {
+			err = flush()
+			if err != nil {
+				return progress, err
+			}
+		}
+	}
+}
+
+/**
+ * 写入一批记录，TxPerBatch时在独立的事务中写入
+ */
+func (this *GormProxy) writeBulkBatch(data interface{}, options *BulkLoadOptions) error {
+	write := func(proxy *GormProxy) error {
+		if options.LoadData {
+			_, err := proxy.loadDataRows(data, options.Upsert)
+			return err
+		}
+		batchOptions := options.BatchOptions
+		batchOptions.BatchSize = reflect.ValueOf(data).Len()
+		return proxy.BatchInsertWith(data, &batchOptions)
+	}
+	if options.TxPerBatch {
+		return this.runInTx("", write)
+	}
+	return write(this)
+}
+
+/**
+ * LOAD DATA读取器的名称序号
+ */
+var loadDataReaderSeq uint64
+
+/**
+ * 通过MySQL的LOAD DATA LOCAL INFILE将reader中的数据导入表中，数据格式为：字段以\t分隔，行以\n结束，
+ * 特殊字符以\转义，NULL写为\N（见encodeLoadDataRows）。columns为数据中各字段对应的列，返回导入的行数
+ */
+func (this *GormProxy) LoadDataLocal(table string, columns []string, reader io.Reader) (int64, error) {
+	return this.loadDataLocal(table, columns, reader, false)
+}
+
+/**
+ * 通过LOAD DATA LOCAL INFILE导入数据，replace为true时以新记录替换唯一键冲突的已有记录，否则跳过冲突的新记录（LOCAL导入的默认行为）
+ */
+func (this *GormProxy) loadDataLocal(table string, columns []string, reader io.Reader, replace bool) (int64, error) {
+	if this.Conn == nil {
+		return 0, errors.New("gorm: load data failed. db conn is nil")
+	}
+	readerName := fmt.Sprintf("simpleapi_load_data_%d", atomic.AddUint64(&loadDataReaderSeq, 1))
+	mysql.RegisterReaderHandler(readerName, func() io.Reader {
+		return reader
+	})
+	defer mysql.DeregisterReaderHandler(readerName)
+	db := this.Conn.Exec(loadDataSQL(readerName, table, columns, replace))
+	if db.Error != nil {
+		return 0, fmt.Errorf("gorm: load data into %s failed. %w", table, db.Error)
+	}
+	return db.RowsAffected, nil
+}
+
+/**
+ * 将结构体切片编码到内存中，并通过LOAD DATA LOCAL INFILE导入，列名按gorm的Schema解析，自增主键由数据库生成
+ */
+func (this *GormProxy) LoadDataRows(data interface{}) (int64, error) {
+	return this.loadDataRows(data, false)
+}
+
+/**
+ * 编码并导入结构体切片，replace见loadDataLocal
+ */
+func (this *GormProxy) loadDataRows(data interface{}, replace bool) (int64, error) {
+	dataVal, modelSchema, err := this.parseBatchData(data)
+	if err != nil {
+		return 0, err
+	}
+	if dataVal.Len() == 0 {
+		return 0, nil
+	}
+	loc := time.UTC
+	if options := this.GetRoot().options; options != nil && options.Loc != nil {
+		loc = options.Loc
+	}
+	fields, columns := loadDataFields(modelSchema)
+	buffer := new(bytes.Buffer)
+	err = encodeLoadDataRows(buffer, dataVal, fields, loc)
+	if err != nil {
+		return 0, err
+	}
+	return this.loadDataLocal(modelSchema.Table, columns, buffer, replace)
+}
+
+/**
+ * 生成LOAD DATA LOCAL INFILE语句，replace为true时使用REPLACE处理唯一键冲突
+ */
+func loadDataSQL(readerName, table string, columns []string, replace bool) string {
+	quotedColumns := make([]string, len(columns))
+	for i, column := range columns {
+		quotedColumns[i] = "`" + strings.ReplaceAll(column, "`", "``") + "`"
+	}
+	duplicate := ""
+	if replace {
+		duplicate = "REPLACE "
+	}
+	return fmt.Sprintf("LOAD DATA LOCAL INFILE 'Reader::%s' %sINTO TABLE `%s` CHARACTER SET utf8mb4 "+
+		"FIELDS TERMINATED BY '\\t' ESCAPED BY '\\\\' LINES TERMINATED BY '\\n' (%s)",
+		readerName, duplicate, strings.ReplaceAll(table, "`", "``"), strings.Join(quotedColumns, ", "))
+}
+
+/**
+ * 获取LOAD DATA写入的字段及列，跳过不可写入的字段及自增主键（由数据库生成，避免零值主键写入冲突）
+ */
+func loadDataFields(modelSchema *schema.Schema) ([]*schema.Field, []string) {
+	var fields []*schema.Field
+	var columns []string
+	for _, field := range modelSchema.Fields {
+		if field.DBName == "" || !field.Creatable || field.AutoIncrement {
+			continue
+		}
+		fields = append(fields, field)
+		columns = append(columns, field.DBName)
+	}
+	return fields, columns
+}
+
+/**
+ * 将记录编码为LOAD DATA的默认格式写入w，time.Time按loc格式化
+ */
+func encodeLoadDataRows(w io.Writer, dataVal reflect.Value, fields []*schema.Field, loc *time.Location) error {
+	ctx := context.Background()
+	var line []byte
+	for i := 0; i < dataVal.Len(); i++ {
+		elem := reflect.Indirect(dataVal.Index(i))
+		line = line[:0]
+		for j, field := range fields {
+			if j > 0 {
+				line = append(line, '\t')
+			}
+			value, _ := field.ValueOf(ctx, elem)
+			var err error
+			line, err = appendLoadDataValue(line, value, loc)
+			if err != nil {
+				return fmt.Errorf("gorm: encode column %s of row %d failed. %w", field.DBName, i, err)
+			}
+		}
+		line = append(line, '\n')
+		_, err := w.Write(line)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+/**
+ * 将一个字段值编码后追加到buf
+ */
+func appendLoadDataValue(buf []byte, value interface{}, loc *time.Location) ([]byte, error) {
+	if valuer, ok := value.(driver.Valuer); ok {
+		valuerVal := reflect.ValueOf(valuer)
+		if valuerVal.Kind() == reflect.Ptr && valuerVal.IsNil() {
+			return append(buf, `\N`...), nil
+		}
+		var err error
+		value, err = valuer.Value()
+		if err != nil {
+			return buf, err
+		}
+	}
+	if value == nil {
+		return append(buf, `\N`...), nil
+	}
+	rv := reflect.ValueOf(value)
+	if rv.Kind() == reflect.Ptr {
+		if rv.IsNil() {
+			return append(buf, `\N`...), nil
+		}
+		return appendLoadDataValue(buf, rv.Elem().Interface(), loc)
+	}
+	switch v := value.(type) {
+	case time.Time:
+		if v.IsZero() {
+			return append(buf, "0000-00-00 00:00:00"...), nil
+		}
+		return v.In(loc).AppendFormat(buf, "2006-01-02 15:04:05.999999"), nil
+	case []byte:
+		return appendLoadDataEscaped(buf, string(v)), nil
+	case string:
+		return appendLoadDataEscaped(buf, v), nil
+	case bool:
+		if v {
+			return append(buf, '1'), nil
+		}
+		return append(buf, '0'), nil
+	}
+	switch rv.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return strconv.AppendInt(buf, rv.Int(), 10), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return strconv.AppendUint(buf, rv.Uint(), 10), nil
+	case reflect.Float32, reflect.Float64:
+		return strconv.AppendFloat(buf, rv.Float(), 'g', -1, 64), nil
+	case reflect.String:
+		return appendLoadDataEscaped(buf, rv.String()), nil
+	case reflect.Bool:
+		return appendLoadDataValue(buf, rv.Bool(), loc)
+	}
+	return buf, fmt.Errorf("unsupported value type %T", value)
+}
+
+/**
+ * 转义字段中的特殊字符
+ */
+func appendLoadDataEscaped(buf []byte, s string) []byte {
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			buf = append(buf, '\\', '\\')
+		case '\t':
+			buf = append(buf, '\\', 't')
+		case '\n':
+			buf = append(buf, '\\', 'n')
+		case '\r':
+			buf = append(buf, '\\', 'r')
+		case 0:
+			buf = append(buf, '\\', '0')
+		default:
+			buf = append(buf, s[i])
+		}
+	}
+	return buf
+}
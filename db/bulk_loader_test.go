@@ -0,0 +1,86 @@
+package db
+
+import (
+	"bytes"
+	"database/sql"
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+type bulkTestRow struct {
+	Id        int64
+	Name      string
+	Score     float64
+	Enabled   bool
+	Remark    *string
+	Nick      sql.NullString
+	CreatedAt time.Time
+	Skipped   string `gorm:"-"`
+}
+
+func TestEncodeLoadDataRows(t *testing.T) {
+	proxy := newBatchTestProxy()
+	remark := "a\tb\nc\\d"
+	createdAt := time.Date(2020, 1, 2, 3, 4, 5, 600000000, time.UTC)
+	rows := []*bulkTestRow{
+		{Id: 1, Name: "x\r\x00y", Score: 1.5, Enabled: true, Remark: &remark, CreatedAt: createdAt},
+		{Name: "", Nick: sql.NullString{String: "nick", Valid: true}},
+	}
+	dataVal, modelSchema, err := proxy.parseBatchData(rows)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fields, columns := loadDataFields(modelSchema)
+	//自增主键由数据库生成，不写入
+	if strings.Join(columns, ",") != "name,score,enabled,remark,nick,created_at" {
+		t.Fatalf("unexpected columns %v", columns)
+	}
+	buffer := new(bytes.Buffer)
+	err = encodeLoadDataRows(buffer, dataVal, fields, time.FixedZone("CST", 8*3600))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := "x\\r\\0y\t1.5\t1\ta\\tb\\nc\\\\d\t\\N\t2020-01-02 11:04:05.6\n" +
+		"\t0\t0\t\\N\tnick\t0000-00-00 00:00:00\n"
+	if buffer.String() != expected {
+		t.Fatalf("unexpected encoded rows:\n%q\n%q", buffer.String(), expected)
+	}
+	if _, err = appendLoadDataValue(nil, []int{1}, time.UTC); err == nil {
+		t.Fatal("unsupported value should fail")
+	}
+}
+
+func TestLoadDataSQL(t *testing.T) {
+	sql := loadDataSQL("r1", "user`s", []string{"id", "name"}, false)
+	expected := "LOAD DATA LOCAL INFILE 'Reader::r1' INTO TABLE `user``s` CHARACTER SET utf8mb4 " +
+		"FIELDS TERMINATED BY '\\t' ESCAPED BY '\\\\' LINES TERMINATED BY '\\n' (`id`, `name`)"
+	if sql != expected {
+		t.Fatalf("unexpected load data sql %s", sql)
+	}
+	sql = loadDataSQL("r1", "users", []string{"name"}, true)
+	if !strings.HasPrefix(sql, "LOAD DATA LOCAL INFILE 'Reader::r1' REPLACE INTO TABLE `users`") {
+		t.Fatalf("upsert load data should replace conflicting rows: %s", sql)
+	}
+}
+
+func TestChanSource(t *testing.T) {
+	ch := make(chan *bulkTestRow, 2)
+	ch <- &bulkTestRow{Id: 1}
+	close(ch)
+	source := ChanSource(ch)
+	row, err := source()
+	if err != nil || row.(*bulkTestRow).Id != 1 {
+		t.Fatalf("unexpected row %v %v", row, err)
+	}
+	if _, err = source(); err != io.EOF {
+		t.Fatal("closed chan should be the end of source")
+	}
+	if _, err = ChanSource([]int{})(); err == nil || err == io.EOF {
+		t.Fatal("non chan source should fail")
+	}
+	if _, err = ChanSource(make(chan<- int))(); err == nil || err == io.EOF {
+		t.Fatal("send only chan source should fail")
+	}
+}
@@ -0,0 +1,277 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+/**
+ * 数据库连接选项，包括DSN参数、底层sql.DB连接池配置及SQL日志配置。Charset、Loc为空时使用DefaultConnOptions中的值，
+ * 其它值为零时使用驱动或database/sql的默认值
+ */
+type ConnOptions struct {
+	Charset          string            //字符集，默认utf8mb4
+	Collation        string            //排序规则
+	Loc              *time.Location    //time.Time的时区，默认time.Local
+	DisableParseTime bool              //是否不将DATE、DATETIME解析为time.Time（默认解析）
+	Timeout          time.Duration     //建立连接的超时时间
+	ReadTimeout      time.Duration     //读超时时间
+	WriteTimeout     time.Duration     //写超时时间
+	TLS              string            //TLS配置：true、false、skip-verify、preferred或通过mysql.RegisterTLSConfig注册的名称
+	Params           map[string]string //其它DSN参数
+
+	MaxOpenConns    int           //最大打开连接数
+	MaxIdleConns    int           //最大空闲连接数
+	ConnMaxLifetime time.Duration //连接的最长使用时间
+	ConnMaxIdleTime time.Duration //连接的最长空闲时间
+
+	SlowThreshold time.Duration //慢查询阈值，为0时使用DEFAULT_SLOW_SQL_THRESHOLD，小于0时不记录慢查询
+	LogStatements bool          //是否以DEBUG级别记录所有SQL语句
+}
+
+/**
+ * 获取默认的连接选项
+ */
+func DefaultConnOptions() *ConnOptions {
+	return &ConnOptions{Charset: "utf8mb4", Loc: time.Local}
+}
+
+/**
+ * 获取以DefaultConnOptions填充了空字段的连接选项副本，使调用方只需设置关心的选项
+ */
+func (this *ConnOptions) withDefaults() *ConnOptions {
+	options := *this
+	defaults := DefaultConnOptions()
+	if options.Charset == "" {
+		options.Charset = defaults.Charset
+	}
+	if options.Loc == nil {
+		options.Loc = defaults.Loc
+	}
+	return &options
+}
+
+/**
+ * 按连接选项生成MySQL的DSN
+ */
+func (this *ConnOptions) mysqlDSN(host, port, user, pass, database string) string {
+	options := this.withDefaults()
+	config := mysql.NewConfig()
+	config.User = user
+	config.Passwd = pass
+	config.Net = "tcp"
+	config.Addr = net.JoinHostPort(host, port)
+	config.DBName = database
+	config.Params = make(map[string]string)
+	for key, value := range options.Params {
+		config.Params[key] = value
+	}
+	config.Params["charset"] = options.Charset
+	if options.Collation != "" {
+		config.Collation = options.Collation
+	}
+	config.Loc = options.Loc
+	config.ParseTime = !options.DisableParseTime
+	config.Timeout = options.Timeout
+	config.ReadTimeout = options.ReadTimeout
+	config.WriteTimeout = options.WriteTimeout
+	config.TLSConfig = options.TLS
+	return config.FormatDSN()
+}
+
+/**
+ * 按连接选项生成PostgreSQL的DSN：TLS映射为sslmode，Timeout映射为connect_timeout，Loc不是time.Local时设置TimeZone
+ */
+func (this *ConnOptions) postgresDSN(host, port, user, pass, database string) string {
+	params := map[string]string{
+		"host":     host,
+		"port":     port,
+		"user":     user,
+		"password": pass,
+		"dbname":   database,
+		"sslmode":  postgresSSLMode(this.TLS),
+	}
+	if this.Timeout > 0 {
+		params["connect_timeout"] = strconv.Itoa(int((this.Timeout + time.Second - 1) / time.Second))
+	}
+	if this.Loc != nil && this.Loc != time.Local {
+		params["TimeZone"] = this.Loc.String()
+	}
+	for key, value := range this.Params {
+		params[key] = value
+	}
+	keys := make([]string, 0, len(params))
+	for key := range params {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	pairs := make([]string, 0, len(keys))
+	for _, key := range keys {
+		if params[key] == "" {
+			continue
+		}
+		pairs = append(pairs, fmt.Sprintf("%s='%s'", key, strings.NewReplacer(`\`, `\\`, `'`, `\'`).Replace(params[key])))
+	}
+	return strings.Join(pairs, " ")
+}
+
+/**
+ * 将TLS配置转换为PostgreSQL的sslmode
+ */
+func postgresSSLMode(tls string) string {
+	switch tls {
+	case "", "false":
+		return "disable"
+	case "true", "skip-verify":
+		return "require"
+	case "preferred":
+		return "prefer"
+	default:
+		return tls
+	}
+}
+
+/**
+ * 按连接选项生成文件型数据库（SQLite）的DSN，Params作为查询参数追加在路径之后
+ */
+func (this *ConnOptions) fileDSN(path string) string {
+	if len(this.Params) == 0 {
+		return path
+	}
+	values := url.Values{}
+	for key, value := range this.Params {
+		values.Add(key, value)
+	}
+	separator := "?"
+	if strings.Contains(path, "?") {
+		separator = "&"
+	}
+	return path + separator + values.Encode()
+}
+
+/**
+ * 将连接池配置应用到底层的sql.DB
+ */
+func (this *ConnOptions) applyPool(sqlDB *sql.DB) {
+	if this.MaxOpenConns > 0 {
+		sqlDB.SetMaxOpenConns(this.MaxOpenConns)
+	}
+	if this.MaxIdleConns > 0 {
+		sqlDB.SetMaxIdleConns(this.MaxIdleConns)
+	}
+	if this.ConnMaxLifetime > 0 {
+		sqlDB.SetConnMaxLifetime(this.ConnMaxLifetime)
+	}
+	if this.ConnMaxIdleTime > 0 {
+		sqlDB.SetConnMaxIdleTime(this.ConnMaxIdleTime)
+	}
+}
+
+/**
+ * 按连接选项打开MySQL数据库连接，options为nil时使用默认选项
+ */
+func (this *GormProxy) OpenMySQLWithOptions(host, port, user, pass, database string, options *ConnOptions) error {
+	return this.OpenWithOptions(DIALECT_MYSQL, host, port, user, pass, database, options)
+}
+
+/**
+ * 调整连接池配置，只有连接池相关的选项生效
+ */
+func (this *GormProxy) ConfigurePool(options *ConnOptions) error {
+	sqlDB, err := this.sqlDB()
+	if err != nil {
+		return err
+	}
+	options.applyPool(sqlDB)
+	return nil
+}
+
+/**
+ * 获取底层的sql.DB
+ */
+func (this *GormProxy) sqlDB() (*sql.DB, error) {
+	if this.Conn == nil {
+		return nil, errors.New("gorm: db conn is nil")
+	}
+	return this.Conn.DB()
+}
+
+/**
+ * 检查数据库连接是否可用
+ */
+func (this *GormProxy) Ping() error {
+	return this.PingContext(context.Background())
+}
+
+/**
+ * 在ctx的时限内检查数据库连接是否可用
+ */
+func (this *GormProxy) PingContext(ctx context.Context) error {
+	sqlDB, err := this.sqlDB()
+	if err != nil {
+		return err
+	}
+	err = sqlDB.PingContext(ctx)
+	if err != nil {
+		return fmt.Errorf("gorm: ping database failed. error:%w", err)
+	}
+	return nil
+}
+
+/**
+ * 获取连接池的统计信息
+ */
+func (this *GormProxy) PoolStats() (sql.DBStats, error) {
+	sqlDB, err := this.sqlDB()
+	if err != nil {
+		return sql.DBStats{}, err
+	}
+	return sqlDB.Stats(), nil
+}
+
+/**
+ * 关闭数据库连接及通过SqlProxy缓存的预编译语句，同时停止从库健康检查并关闭所有从库。事务连接不能被关闭
+ */
+func (this *GormProxy) Close() error {
+	if this.inTx {
+		return errors.New("gorm: can not close a transaction conn")
+	}
+	this.StopReplicaHealthCheck()
+	var errs []string
+	if set := this.getReplicas(); set != nil {
+		set.lock.Lock()
+		replicas := set.replicas
+		set.lock.Unlock()
+		for _, replica := range replicas {
+			sqlDB, err := replica.conn.DB()
+			if err == nil {
+				err = sqlDB.Close()
+			}
+			if err != nil {
+				errs = append(errs, err.Error())
+			}
+		}
+	}
+	sqlDB, err := this.sqlDB()
+	if err == nil {
+		closeStmtCache(sqlDB)
+		err = sqlDB.Close()
+	}
+	if err != nil {
+		errs = append(errs, err.Error())
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("gorm: close database failed.error:%v", errs)
+	}
+	return nil
+}
@@ -0,0 +1,69 @@
+package db
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMySQLDSN(t *testing.T) {
+	dsn := DefaultConnOptions().mysqlDSN("127.0.0.1", "3306", "root", "p@ss", "test")
+	for _, part := range []string{"root:p@ss@tcp(127.0.0.1:3306)/test?", "charset=utf8mb4", "loc=Local", "parseTime=true"} {
+		if !strings.Contains(dsn, part) {
+			t.Fatalf("dsn %s should contain %s", dsn, part)
+		}
+	}
+	//只设置连接池参数的选项仍使用默认的DSN参数
+	dsn = (&ConnOptions{MaxOpenConns: 10}).mysqlDSN("127.0.0.1", "3306", "root", "", "test")
+	for _, part := range []string{"charset=utf8mb4", "loc=Local", "parseTime=true"} {
+		if !strings.Contains(dsn, part) {
+			t.Fatalf("dsn %s of zero value options should contain %s", dsn, part)
+		}
+	}
+	options := &ConnOptions{Charset: "utf8", DisableParseTime: true, Timeout: 3 * time.Second, ReadTimeout: 5 * time.Second, TLS: "skip-verify", Params: map[string]string{"sql_mode": "'STRICT_ALL_TABLES'"}}
+	dsn = options.mysqlDSN("db.local", "3307", "app", "secret", "orders")
+	for _, part := range []string{"tcp(db.local:3307)/orders?", "charset=utf8", "timeout=3s", "readTimeout=5s", "tls=skip-verify", "sql_mode="} {
+		if !strings.Contains(dsn, part) {
+			t.Fatalf("dsn %s should contain %s", dsn, part)
+		}
+	}
+	if strings.Contains(dsn, "parseTime") {
+		t.Fatalf("dsn %s should not enable parseTime", dsn)
+	}
+}
+
+func TestClosedProxy(t *testing.T) {
+	proxy := new(GormProxy)
+	if proxy.Ping() == nil {
+		t.Fatal("ping without conn should fail")
+	}
+	if _, err := proxy.PoolStats(); err == nil {
+		t.Fatal("pool stats without conn should fail")
+	}
+	tx := &GormProxy{inTx: true}
+	if tx.Close() == nil {
+		t.Fatal("transaction conn should not be closed")
+	}
+}
+
+func TestPostgresDSN(t *testing.T) {
+	options := &ConnOptions{Timeout: 1500 * time.Millisecond, TLS: "true"}
+	dsn := options.postgresDSN("db.local", "5432", "app", "it's", "orders")
+	expected := `connect_timeout='2' dbname='orders' host='db.local' password='it\'s' port='5432' sslmode='require' user='app'`
+	if dsn != expected {
+		t.Fatalf("dsn should be %s, got %s", expected, dsn)
+	}
+	if DefaultConnOptions().fileDSN(":memory:") != ":memory:" {
+		t.Fatal("file dsn without params should be the path")
+	}
+	if _, ok := getDialect("unknown"); ok {
+		t.Fatal("unknown dialect should not be registered")
+	}
+}
+
+func TestPostgresDialectNotBuiltin(t *testing.T) {
+	err := new(GormProxy).OpenPostgres("127.0.0.1", "1", "app", "pass", "orders")
+	if err == nil || !strings.Contains(err.Error(), "unknown db dialect") {
+		t.Fatalf("postgres dialect should only be registered by db/postgres: %v", err)
+	}
+}
@@ -0,0 +1,192 @@
+package db
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"strings"
+
+	"github.com/go-sql-driver/mysql"
+	"gorm.io/gorm"
+)
+
+/**
+ * 数据库错误的分类，通过ClassifyError获取
+ */
+const (
+	DB_ERR_NONE        = iota //没有错误
+	DB_ERR_UNKNOWN            //无法归类的错误
+	DB_ERR_NOT_FOUND          //记录不存在
+	DB_ERR_DUPLICATE          //唯一键冲突
+	DB_ERR_FOREIGN_KEY        //外键约束冲突
+	DB_ERR_DEADLOCK           //死锁或序列化失败，可以重新执行事务
+	DB_ERR_CONN_LOST          //数据库连接断开
+	DB_ERR_TIMEOUT            //锁等待超时或语句执行超时
+)
+
+/**
+ * 用于分类的MySQL错误号
+ */
+const (
+	MYSQL_ERR_SERVER_SHUTDOWN    = 1053
+	MYSQL_ERR_DUP_ENTRY          = 1062
+	MYSQL_ERR_NO_REFERENCED_ROW  = 1216
+	MYSQL_ERR_ROW_IS_REFERENCED  = 1217
+	MYSQL_ERR_ROW_IS_REFERENCED2 = 1451
+	MYSQL_ERR_NO_REFERENCED_ROW2 = 1452
+	MYSQL_ERR_DUP_ENTRY_WITH_KEY = 1586
+	MYSQL_ERR_QUERY_TIMEOUT      = 3024
+	MYSQL_ERR_CONN_KILLED        = 1927
+)
+
+/**
+ * 用于分类的SQLite（扩展）错误码
+ */
+const (
+	SQLITE_ERR_BUSY                  = 5
+	SQLITE_ERR_CONSTRAINT_FOREIGNKEY = 787
+	SQLITE_ERR_CONSTRAINT_PRIMARYKEY = 1555
+	SQLITE_ERR_CONSTRAINT_UNIQUE     = 2067
+)
+
+/**
+ * PostgreSQL驱动（pgconn.PgError）的错误接口，通过接口识别以避免依赖具体的驱动
+ */
+type sqlStateError interface {
+	SQLState() string
+}
+
+/**
+ * SQLite驱动（modernc.org/sqlite的sqlite.Error）的错误接口，通过接口识别以避免依赖具体的驱动
+ */
+type sqliteCodeError interface {
+	Code() int
+}
+
+/**
+ * 对数据库错误分类，通过errors.Is/As识别gorm、database/sql及MySQL、PostgreSQL、SQLite驱动的错误，
+ * 其他错误（包括网络错误及context超时）返回DB_ERR_UNKNOWN
+ */
+func ClassifyError(err error) int {
+	if err == nil {
+		return DB_ERR_NONE
+	}
+	switch {
+	case errors.Is(err, gorm.ErrRecordNotFound), errors.Is(err, sql.ErrNoRows):
+		return DB_ERR_NOT_FOUND
+	case errors.Is(err, gorm.ErrDuplicatedKey):
+		return DB_ERR_DUPLICATE
+	case errors.Is(err, gorm.ErrForeignKeyViolated):
+		return DB_ERR_FOREIGN_KEY
+	case errors.Is(err, driver.ErrBadConn), errors.Is(err, mysql.ErrInvalidConn), errors.Is(err, sql.ErrConnDone):
+		return DB_ERR_CONN_LOST
+	}
+	var mysqlErr *mysql.MySQLError
+	if errors.As(err, &mysqlErr) {
+		return classifyMySQLError(mysqlErr.Number)
+	}
+	var stateErr sqlStateError
+	if errors.As(err, &stateErr) {
+		return classifySqlState(stateErr.SQLState())
+	}
+	var codeErr sqliteCodeError
+	if errors.As(err, &codeErr) {
+		return classifySqliteError(codeErr.Code())
+	}
+	return DB_ERR_UNKNOWN
+}
+
+/**
+ * 按MySQL错误号分类
+ */
+func classifyMySQLError(number uint16) int {
+	switch number {
+	case MYSQL_ERR_DUP_ENTRY, MYSQL_ERR_DUP_ENTRY_WITH_KEY:
+		return DB_ERR_DUPLICATE
+	case MYSQL_ERR_NO_REFERENCED_ROW, MYSQL_ERR_ROW_IS_REFERENCED, MYSQL_ERR_ROW_IS_REFERENCED2, MYSQL_ERR_NO_REFERENCED_ROW2:
+		return DB_ERR_FOREIGN_KEY
+	case MYSQL_ERR_DEADLOCK:
+		return DB_ERR_DEADLOCK
+	case MYSQL_ERR_LOCK_WAIT_TIMEOUT, MYSQL_ERR_QUERY_TIMEOUT:
+		return DB_ERR_TIMEOUT
+	case MYSQL_ERR_SERVER_SHUTDOWN, MYSQL_ERR_CONN_KILLED:
+		return DB_ERR_CONN_LOST
+	}
+	return DB_ERR_UNKNOWN
+}
+
+/**
+ * 按PostgreSQL的SQLSTATE分类
+ */
+func classifySqlState(state string) int {
+	switch {
+	case state == "23505":
+		return DB_ERR_DUPLICATE
+	case state == "23503":
+		return DB_ERR_FOREIGN_KEY
+	case state == "40001", state == "40P01":
+		return DB_ERR_DEADLOCK
+	case state == "57014", state == "55P03":
+		return DB_ERR_TIMEOUT
+	case strings.HasPrefix(state, "08"), state == "57P01":
+		return DB_ERR_CONN_LOST
+	}
+	return DB_ERR_UNKNOWN
+}
+
+/**
+ * 按SQLite的扩展错误码分类
+ */
+func classifySqliteError(code int) int {
+	switch code {
+	case SQLITE_ERR_CONSTRAINT_UNIQUE, SQLITE_ERR_CONSTRAINT_PRIMARYKEY:
+		return DB_ERR_DUPLICATE
+	case SQLITE_ERR_CONSTRAINT_FOREIGNKEY:
+		return DB_ERR_FOREIGN_KEY
+	case SQLITE_ERR_BUSY:
+		return DB_ERR_TIMEOUT
+	}
+	return DB_ERR_UNKNOWN
+}
+
+/**
+ * 判断是否为记录不存在错误（gorm.ErrRecordNotFound或sql.ErrNoRows）
+ */
+func IsNotFoundError(err error) bool {
+	return ClassifyError(err) == DB_ERR_NOT_FOUND
+}
+
+/**
+ * 判断是否为唯一键冲突错误
+ */
+func IsDuplicateError(err error) bool {
+	return ClassifyError(err) == DB_ERR_DUPLICATE
+}
+
+/**
+ * 判断是否为外键约束冲突错误
+ */
+func IsForeignKeyError(err error) bool {
+	return ClassifyError(err) == DB_ERR_FOREIGN_KEY
+}
+
+/**
+ * 判断是否为死锁错误
+ */
+func IsDeadlockError(err error) bool {
+	return ClassifyError(err) == DB_ERR_DEADLOCK
+}
+
+/**
+ * 判断是否为连接断开错误
+ */
+func IsConnLostError(err error) bool {
+	return ClassifyError(err) == DB_ERR_CONN_LOST
+}
+
+/**
+ * 判断是否为超时错误
+ */
+func IsTimeoutError(err error) bool {
+	return ClassifyError(err) == DB_ERR_TIMEOUT
+}
@@ -0,0 +1,83 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net"
+	"testing"
+
+	"github.com/go-sql-driver/mysql"
+	"gorm.io/gorm"
+)
+
+type testSqlStateError struct {
+	state string
+}
+
+func (this *testSqlStateError) Error() string {
+	return "pg error " + this.state
+}
+
+func (this *testSqlStateError) SQLState() string {
+	return this.state
+}
+
+type testSqliteError struct {
+	code int
+}
+
+func (this *testSqliteError) Error() string {
+	return fmt.Sprintf("sqlite error (%d)", this.code)
+}
+
+func (this *testSqliteError) Code() int {
+	return this.code
+}
+
+func TestClassifyError(t *testing.T) {
+	cases := []struct {
+		err  error
+		kind int
+	}{
+		{nil, DB_ERR_NONE},
+		{errors.New("some error"), DB_ERR_UNKNOWN},
+		{fmt.Errorf("find order failed. %w", gorm.ErrRecordNotFound), DB_ERR_NOT_FOUND},
+		{sql.ErrNoRows, DB_ERR_NOT_FOUND},
+		{&mysql.MySQLError{Number: 1062, Message: "Duplicate entry"}, DB_ERR_DUPLICATE},
+		{fmt.Errorf("insert failed. %w", &mysql.MySQLError{Number: 1452}), DB_ERR_FOREIGN_KEY},
+		{&mysql.MySQLError{Number: 1213}, DB_ERR_DEADLOCK},
+		{&mysql.MySQLError{Number: 1205}, DB_ERR_TIMEOUT},
+		{&mysql.MySQLError{Number: 1064}, DB_ERR_UNKNOWN},
+		{mysql.ErrInvalidConn, DB_ERR_CONN_LOST},
+		{&testSqlStateError{"23505"}, DB_ERR_DUPLICATE},
+		{&testSqlStateError{"08006"}, DB_ERR_CONN_LOST},
+		{fmt.Errorf("save user failed. %w", &testSqliteError{2067}), DB_ERR_DUPLICATE},
+		{&testSqliteError{787}, DB_ERR_FOREIGN_KEY},
+		//只识别驱动的错误类型，非数据库错误及丢失了错误类型的错误信息不归类
+		{context.DeadlineExceeded, DB_ERR_UNKNOWN},
+		{&net.OpError{Op: "dial", Err: errors.New("connection refused")}, DB_ERR_UNKNOWN},
+		{errors.New("Error 1062 (23000): Duplicate entry 'a' for key 'code'"), DB_ERR_UNKNOWN},
+		{errors.New("order record not found in cache"), DB_ERR_UNKNOWN},
+	}
+	for _, c := range cases {
+		if kind := ClassifyError(c.err); kind != c.kind {
+			t.Fatalf("error %v should be classified as %d, got %d", c.err, c.kind, kind)
+		}
+	}
+}
+
+func TestIsNoRowError(t *testing.T) {
+	gormProxy := new(GormProxy)
+	sqlProxy := new(MySqlProxy)
+	if gormProxy.IsNoRowError(nil) || sqlProxy.IsNoRowError(nil) {
+		t.Fatal("nil error is not a no row error")
+	}
+	if !gormProxy.IsNoRowError(gorm.ErrRecordNotFound) || !sqlProxy.IsNoRowError(sql.ErrNoRows) {
+		t.Fatal("no row errors of gorm and database/sql should be detected")
+	}
+	if gormProxy.IsNoRowError(errors.New("syntax error")) {
+		t.Fatal("other errors are not no row errors")
+	}
+}
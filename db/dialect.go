@@ -0,0 +1,68 @@
+package db
+
+import (
+	"sync"
+
+	"gorm.io/driver/mysql"
+	"gorm.io/gorm"
+)
+
+/**
+ * 内置及约定的数据库方言名称
+ */
+const (
+	DIALECT_MYSQL    = "mysql"
+	DIALECT_POSTGRES = "postgres" //由db/postgres包注册
+	DIALECT_SQLITE   = "sqlite"   //由db/sqlite包注册
+)
+
+/**
+ * 根据DSN创建gorm方言的函数，例如mysql.Open
+ */
+type DialectOpener func(dsn string) gorm.Dialector
+
+/**
+ * 方言未知时单条SQL允许的最大占位符数（SQLite 3.32之前的版本为999）
+ */
+const DEFAULT_MAX_PLACEHOLDERS = 999
+
+var (
+	//各方言单条SQL允许的最大占位符数，批量操作按此拆分批次
+	dialectMaxPlaceholders = map[string]int{DIALECT_MYSQL: 65535, DIALECT_POSTGRES: 65535, DIALECT_SQLITE: 32766}
+	dialects               = map[string]DialectOpener{DIALECT_MYSQL: mysql.Open}
+	dialectLock            sync.RWMutex
+)
+
+/**
+ * 注册一个数据库方言，注册后即可通过GormProxy.Open按名称打开该方言的数据库。
+ * 除mysql、postgres之外的方言按文件型数据库处理，Open的hostOrPath参数作为DSN
+ */
+func RegisterDialect(dialect string, opener DialectOpener) {
+	dialectLock.Lock()
+	defer dialectLock.Unlock()
+	dialects[dialect] = opener
+}
+
+/**
+ * 获取已注册的数据库方言
+ */
+func getDialect(dialect string) (DialectOpener, bool) {
+	dialectLock.RLock()
+	defer dialectLock.RUnlock()
+	opener, ok := dialects[dialect]
+	return opener, ok
+}
+
+/**
+ * 获取连接所用方言单条SQL允许的最大占位符数
+ */
+func (this *GormProxy) maxPlaceholders() int {
+	if this.Conn == nil || this.Conn.Dialector == nil {
+		return DEFAULT_MAX_PLACEHOLDERS
+	}
+	maxPlaceholders, ok := dialectMaxPlaceholders[this.Conn.Dialector.Name()]
+	if !ok {
+		return DEFAULT_MAX_PLACEHOLDERS
+	}
+	return maxPlaceholders
+}
@@ -0,0 +1,257 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+	"net/url"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"gorm.io/gorm/schema"
+)
+
+/**
+ * 过滤条件的操作符，查询参数的格式为 参数名=操作符:值，例如status=in:1,2、created_at=gte:2026-01-01，
+ * 不带已知操作符前缀的值按eq处理
+ */
+const (
+	FILTER_OP_EQ      = "eq"
+	FILTER_OP_NE      = "ne"
+	FILTER_OP_IN      = "in"   //多个值以逗号分隔
+	FILTER_OP_LIKE    = "like" //包含匹配，值中的%及_按普通字符处理
+	FILTER_OP_GT      = "gt"
+	FILTER_OP_GTE     = "gte"
+	FILTER_OP_LT      = "lt"
+	FILTER_OP_LTE     = "lte"
+	FILTER_OP_BETWEEN = "between" //两个值以逗号分隔，包含边界
+	FILTER_OP_NULL    = "null"    //值为true或空时为IS NULL，false时为IS NOT NULL
+)
+
+/**
+ * 过滤时支持的时间格式，按顺序尝试，没有时区的时间按本地时区解析
+ */
+var filterTimeLayouts = []string{time.RFC3339Nano, "2006-01-02 15:04:05", "2006-01-02T15:04:05", "2006-01-02"}
+
+/**
+ * LIKE使用的转义字符，各数据库对'\'在字符串字面量中的处理不一致，因此使用'!'
+ */
+const likeEscapeChar = '!'
+
+/**
+ * 一个过滤条件，值在应用到查询时按模型字段的类型转换
+ */
+type Filter struct {
+	Param  string
+	Column string
+	Op     string
+	Values []string
+}
+
+/**
+ * 从查询字符串绑定过滤条件：fields为允许过滤的参数名到列名的白名单，不在白名单中的参数（如分页参数）被忽略，
+ * 同一参数出现多次时生成多个条件（例如created_at=gte:2026-01-01&created_at=lt:2026-02-01）
+ */
+func ParseFilters(query url.Values, fields map[string]string) ([]*Filter, error) {
+	params := make([]string, 0, len(fields))
+	for param := range fields {
+		params = append(params, param)
+	}
+	//按参数名排序，使生成的SQL稳定
+	sort.Strings(params)
+	var filters []*Filter
+	for _, param := range params {
+		for _, rawValue := range query[param] {
+			filter, err := parseFilter(param, fields[param], rawValue)
+			if err != nil {
+				return nil, err
+			}
+			filters = append(filters, filter)
+		}
+	}
+	return filters, nil
+}
+
+/**
+ * 解析一个过滤参数的值
+ */
+func parseFilter(param, column, rawValue string) (*Filter, error) {
+	filter := &Filter{Param: param, Column: column, Op: FILTER_OP_EQ}
+	value := rawValue
+	if index := strings.Index(rawValue, ":"); index >= 0 && isFilterOp(rawValue[:index]) {
+		filter.Op = rawValue[:index]
+		value = rawValue[index+1:]
+	}
+	switch filter.Op {
+	case FILTER_OP_IN:
+		filter.Values = strings.Split(value, ",")
+	case FILTER_OP_BETWEEN:
+		filter.Values = strings.Split(value, ",")
+		if len(filter.Values) != 2 {
+			return nil, fmt.Errorf("filter %s: between needs two values", param)
+		}
+	case FILTER_OP_NULL:
+		if value != "" && value != "true" && value != "false" {
+			return nil, fmt.Errorf("filter %s: null needs true or false", param)
+		}
+		filter.Values = []string{value}
+	default:
+		filter.Values = []string{value}
+	}
+	return filter, nil
+}
+
+/**
+ * 判断是否为支持的操作符
+ */
+func isFilterOp(op string) bool {
+	switch op {
+	case FILTER_OP_EQ, FILTER_OP_NE, FILTER_OP_IN, FILTER_OP_LIKE, FILTER_OP_GT, FILTER_OP_GTE,
+		FILTER_OP_LT, FILTER_OP_LTE, FILTER_OP_BETWEEN, FILTER_OP_NULL:
+		return true
+	}
+	return false
+}
+
+/**
+ * 将过滤条件应用到查询上：model为查询的模型（结构体指针），过滤的值按模型中对应字段的类型转换，
+ * 转换失败或列不存在时返回错误
+ */
+func ApplyFilters(conn *gorm.DB, model interface{}, filters []*Filter) (*gorm.DB, error) {
+	modelType := reflect.TypeOf(model)
+	for modelType != nil && (modelType.Kind() == reflect.Ptr || modelType.Kind() == reflect.Slice) {
+		modelType = modelType.Elem()
+	}
+	if modelType == nil || modelType.Kind() != reflect.Struct {
+		return nil, fmt.Errorf("gorm: filter model [%T] must be struct ptr", model)
+	}
+	modelSchema, err := parseModelSchema(conn, modelType)
+	if err != nil {
+		return nil, err
+	}
+	query := conn.Model(model)
+	for _, filter := range filters {
+		expression, err := filter.expression(modelSchema)
+		if err != nil {
+			return nil, err
+		}
+		query = query.Where(expression)
+	}
+	return query, nil
+}
+
+/**
+ * 生成过滤条件对应的SQL表达式
+ */
+func (this *Filter) expression(modelSchema *schema.Schema) (clause.Expression, error) {
+	field := modelSchema.LookUpField(this.Column)
+	if field == nil || field.DBName == "" {
+		return nil, fmt.Errorf("filter %s: column %s is not found in %s", this.Param, this.Column, modelSchema.Name)
+	}
+	column := clause.Column{Name: field.DBName}
+	switch this.Op {
+	case FILTER_OP_NULL:
+		if this.Values[0] == "false" {
+			return clause.Neq{Column: column, Value: nil}, nil
+		}
+		return clause.Eq{Column: column, Value: nil}, nil
+	case FILTER_OP_LIKE:
+		return clause.Expr{SQL: fmt.Sprintf("? LIKE ? ESCAPE '%c'", likeEscapeChar), Vars: []interface{}{column, "%" + escapeLike(this.Values[0]) + "%"}}, nil
+	}
+	values := make([]interface{}, len(this.Values))
+	for i, rawValue := range this.Values {
+		value, err := convertFilterValue(rawValue, field.FieldType)
+		if err != nil {
+			return nil, fmt.Errorf("filter %s: invalid value %s. %s", this.Param, rawValue, err.Error())
+		}
+		values[i] = value
+	}
+	switch this.Op {
+	case FILTER_OP_NE:
+		return clause.Neq{Column: column, Value: values[0]}, nil
+	case FILTER_OP_IN:
+		return clause.IN{Column: column, Values: values}, nil
+	case FILTER_OP_GT:
+		return clause.Gt{Column: column, Value: values[0]}, nil
+	case FILTER_OP_GTE:
+		return clause.Gte{Column: column, Value: values[0]}, nil
+	case FILTER_OP_LT:
+		return clause.Lt{Column: column, Value: values[0]}, nil
+	case FILTER_OP_LTE:
+		return clause.Lte{Column: column, Value: values[0]}, nil
+	case FILTER_OP_BETWEEN:
+		return clause.Expr{SQL: "? BETWEEN ? AND ?", Vars: []interface{}{column, values[0], values[1]}}, nil
+	default:
+		return clause.Eq{Column: column, Value: values[0]}, nil
+	}
+}
+
+/**
+ * 转义LIKE中的通配符
+ */
+func escapeLike(value string) string {
+	escape := string(likeEscapeChar)
+	return strings.NewReplacer(escape, escape+escape, "%", escape+"%", "_", escape+"_").Replace(value)
+}
+
+/**
+ * 将查询参数中的字符串值转换为字段类型的值，指针类型转换为其指向的类型，实现了sql.Scanner的类型通过Scan转换
+ */
+func convertFilterValue(rawValue string, fieldType reflect.Type) (interface{}, error) {
+	for fieldType.Kind() == reflect.Ptr {
+		fieldType = fieldType.Elem()
+	}
+	if fieldType == reflect.TypeOf(time.Time{}) {
+		for _, layout := range filterTimeLayouts {
+			value, err := time.ParseInLocation(layout, rawValue, time.Local)
+			if err == nil {
+				return value, nil
+			}
+		}
+		return nil, fmt.Errorf("unsupported time format")
+	}
+	if reflect.PtrTo(fieldType).Implements(reflect.TypeOf((*sql.Scanner)(nil)).Elem()) {
+		value := reflect.New(fieldType)
+		err := value.Interface().(sql.Scanner).Scan(rawValue)
+		if err != nil {
+			return nil, err
+		}
+		return value.Elem().Interface(), nil
+	}
+	value := reflect.New(fieldType).Elem()
+	switch fieldType.Kind() {
+	case reflect.String:
+		value.SetString(rawValue)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(rawValue)
+		if err != nil {
+			return nil, err
+		}
+		value.SetBool(b)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		i, err := strconv.ParseInt(rawValue, 10, fieldType.Bits())
+		if err != nil {
+			return nil, err
+		}
+		value.SetInt(i)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		u, err := strconv.ParseUint(rawValue, 10, fieldType.Bits())
+		if err != nil {
+			return nil, err
+		}
+		value.SetUint(u)
+	case reflect.Float32, reflect.Float64:
+		f, err := strconv.ParseFloat(rawValue, fieldType.Bits())
+		if err != nil {
+			return nil, err
+		}
+		value.SetFloat(f)
+	default:
+		return nil, fmt.Errorf("unsupported field type %s", fieldType.String())
+	}
+	return value.Interface(), nil
+}
@@ -0,0 +1,79 @@
+package db
+
+import (
+	"database/sql"
+	"net/url"
+	"reflect"
+	"testing"
+	"time"
+
+	"gorm.io/gorm/clause"
+)
+
+type filterTestRow struct {
+	Id        int64
+	Status    int8
+	Name      string
+	Score     *float64
+	Nick      sql.NullString
+	CreatedAt time.Time
+	DeletedAt *time.Time
+}
+
+func TestParseFilters(t *testing.T) {
+	fields := map[string]string{"status": "status", "created_at": "created_at", "name": "name"}
+	query, _ := url.ParseQuery("status=in:1,2&created_at=gte:2026-01-01&created_at=lt:2026-02-01&name=a:b&password=x&page=2")
+	filters, err := ParseFilters(query, fields)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []*Filter{
+		{Param: "created_at", Column: "created_at", Op: FILTER_OP_GTE, Values: []string{"2026-01-01"}},
+		{Param: "created_at", Column: "created_at", Op: FILTER_OP_LT, Values: []string{"2026-02-01"}},
+		{Param: "name", Column: "name", Op: FILTER_OP_EQ, Values: []string{"a:b"}},
+		{Param: "status", Column: "status", Op: FILTER_OP_IN, Values: []string{"1", "2"}},
+	}
+	if !reflect.DeepEqual(filters, expected) {
+		t.Fatalf("unexpected filters %+v", filters)
+	}
+	for _, rawQuery := range []string{"status=between:1", "status=null:yes"} {
+		query, _ = url.ParseQuery(rawQuery)
+		if _, err = ParseFilters(query, fields); err == nil {
+			t.Errorf("%s should be rejected", rawQuery)
+		}
+	}
+}
+
+func TestFilterExpression(t *testing.T) {
+	proxy := newBatchTestProxy()
+	modelSchema, _ := proxy.parseSchema(reflect.TypeOf(filterTestRow{}))
+	score := 1.5
+	cases := []struct {
+		filter   *Filter
+		expected clause.Expression
+	}{
+		{&Filter{Column: "status", Op: FILTER_OP_IN, Values: []string{"1", "2"}}, clause.IN{Column: clause.Column{Name: "status"}, Values: []interface{}{int8(1), int8(2)}}},
+		{&Filter{Column: "Score", Op: FILTER_OP_GT, Values: []string{"1.5"}}, clause.Gt{Column: clause.Column{Name: "score"}, Value: score}},
+		{&Filter{Column: "nick", Op: FILTER_OP_NE, Values: []string{"x"}}, clause.Neq{Column: clause.Column{Name: "nick"}, Value: sql.NullString{String: "x", Valid: true}}},
+		{&Filter{Column: "deleted_at", Op: FILTER_OP_NULL, Values: []string{""}}, clause.Eq{Column: clause.Column{Name: "deleted_at"}, Value: nil}},
+		{&Filter{Column: "name", Op: FILTER_OP_LIKE, Values: []string{"a%_!"}}, clause.Expr{SQL: "? LIKE ? ESCAPE '!'", Vars: []interface{}{clause.Column{Name: "name"}, "%a!%!_!!%"}}},
+		{&Filter{Column: "created_at", Op: FILTER_OP_BETWEEN, Values: []string{"2026-01-01", "2026-01-31 23:59:59"}}, clause.Expr{SQL: "? BETWEEN ? AND ?",
+			Vars: []interface{}{clause.Column{Name: "created_at"}, time.Date(2026, 1, 1, 0, 0, 0, 0, time.Local), time.Date(2026, 1, 31, 23, 59, 59, 0, time.Local)}}},
+	}
+	for _, c := range cases {
+		expression, err := c.filter.expression(modelSchema)
+		if err != nil || !reflect.DeepEqual(expression, c.expected) {
+			t.Errorf("unexpected expression of %+v: %v %#v", c.filter, err, expression)
+		}
+	}
+	invalidFilters := []*Filter{
+		{Column: "status", Op: FILTER_OP_EQ, Values: []string{"300"}},
+		{Column: "created_at", Op: FILTER_OP_GT, Values: []string{"yesterday"}},
+		{Column: "password", Op: FILTER_OP_EQ, Values: []string{"x"}},
+	}
+	for _, filter := range invalidFilters {
+		if _, err := filter.expression(modelSchema); err == nil {
+			t.Errorf("%+v should be rejected", filter)
+		}
+	}
+}
@@ -3,21 +3,31 @@ package db
 import (
 	"errors"
 	"fmt"
-	"gorm.io/driver/mysql"
 	"gorm.io/gorm"
-	//_ "github.com/jinzhu/gorm/dialects/sqlite"
-	"reflect"
-	"regexp"
 	"strings"
-	"time"
+	"sync"
 )
 
 /**
  * 基于gorm的数据库连接封装
  */
 type GormProxy struct {
-	Conn *gorm.DB
-	inTx bool
+	Conn         *gorm.DB
+	inTx         bool
+	txFinished   bool       //事务（或保存点）是否已经提交或回滚
+	txDepth      int        //事务嵌套深度：0为非事务连接，1为最外层事务，大于1为通过保存点嵌套的事务
+	savepoint    string     //嵌套事务对应的保存点名称
+	savepointSeq int        //最外层事务已创建的保存点数量，用于生成事务内唯一的保存点名称
+	root         *GormProxy //事务连接所属的非事务连接，用于在已有事务中再开启独立事务
+	outerTx      *GormProxy //嵌套事务所属的最外层事务
+	parentTx     *GormProxy //嵌套事务的上一层事务
+	rollbackOnly bool       //事务被加入者标记为只能回滚
+	onCommit     []func()   //事务提交成功后执行的回调
+	onRollback   []func()   //事务回滚后执行的回调
+	replicas     *replicaSet
+	replicaLock  sync.RWMutex //保护replicas的延迟创建
+	options      *ConnOptions //打开连接时使用的选项，从库沿用相同的选项
+	forcePrimary bool         //读操作也强制使用主库
 }
 
 /**
@@ -28,254 +38,342 @@ func (this *GormProxy) OpenMySQL(host, port, user, pass, database string) error
 }
 
 /**
- * 打开Sqlite3数据库连接（由于编译时需要依赖gcc命令，对Windows不够友好，因此注释掉）
+ * 打开PostgreSQL数据库连接，PostgreSQL方言由db/postgres包提供，使用前需要导入：import _ "github.com/duhaifeng/simpleapi/db/postgres"
  */
-//func (this *GormProxy) OpenSqlite3(filePath string) error {
-//	return this.Open("sqlite3", filePath, "", "", "", "")
-//}
+func (this *GormProxy) OpenPostgres(host, port, user, pass, database string) error {
+	return this.Open(DIALECT_POSTGRES, host, port, user, pass, database)
+}
+
+/**
+ * 打开SQLite数据库连接，filePath为数据库文件路径，传入":memory:"时使用内存数据库。
+ * SQLite方言由纯Go实现的db/sqlite包提供（不依赖cgo），使用前需要导入：import _ "github.com/duhaifeng/simpleapi/db/sqlite"
+ */
+func (this *GormProxy) OpenSqlite(filePath string) error {
+	return this.Open(DIALECT_SQLITE, filePath, "", "", "", "")
+}
 
 /**
- * 打开数据库连接
+ * 打开数据库连接，dialect支持mysql及通过RegisterDialect注册的方言（例如postgres、sqlite）
  */
 func (this *GormProxy) Open(dialect, hostOrPath, port, user, pass, database string) error {
-	var connStr string
-	if dialect == "mysql" {
-		connStr = fmt.Sprintf("%s:%s@tcp(%s:%s)/%s?charset=utf8&loc=Local&parseTime=true", user, pass, hostOrPath, port, database)
-		//} 	else if dialect == "sqlite3" {
-		//	connStr = hostOrPath
-	} else {
-		return errors.New("unknown db dialect " + dialect)
+	return this.OpenWithOptions(dialect, hostOrPath, port, user, pass, database, nil)
+}
+
+/**
+ * 按连接选项打开数据库连接，options为nil时使用默认选项
+ */
+func (this *GormProxy) OpenWithOptions(dialect, hostOrPath, port, user, pass, database string, options *ConnOptions) error {
+	if options == nil {
+		options = DefaultConnOptions()
 	}
-	mysqlConn, err := gorm.Open(mysql.Open(connStr), &gorm.Config{})
+	conn, err := openGormConn(dialect, hostOrPath, port, user, pass, database, options)
 	if err != nil {
 		return err
 	}
-	this.Conn = mysqlConn
+	this.Conn = conn
 	this.inTx = false
+	this.options = options
 	return nil
 }
 
 /**
- * 开启数据库事务，事务会被封装为一个新的连接对象返回
+ * 按方言及连接选项打开一个gorm数据库连接，并配置其连接池
+ */
+func openGormConn(dialect, hostOrPath, port, user, pass, database string, options *ConnOptions) (*gorm.DB, error) {
+	opener, ok := getDialect(dialect)
+	if !ok {
+		return nil, errors.New("unknown db dialect " + dialect)
+	}
+	var connStr string
+	switch dialect {
+	case DIALECT_MYSQL:
+		connStr = options.mysqlDSN(hostOrPath, port, user, pass, database)
+	case DIALECT_POSTGRES:
+		connStr = options.postgresDSN(hostOrPath, port, user, pass, database)
+	default:
+		connStr = options.fileDSN(hostOrPath)
+	}
+	conn, err := gorm.Open(opener(connStr), &gorm.Config{Logger: options.sqlLogger()})
+	if err != nil {
+		return nil, err
+	}
+	sqlDB, err := conn.DB()
+	if err != nil {
+		return nil, err
+	}
+	options.applyPool(sqlDB)
+	//内存数据库的每个连接都是一个独立的库，因此只允许使用一个连接
+	if strings.HasPrefix(hostOrPath, ":memory:") && options.MaxOpenConns == 0 {
+		sqlDB.SetMaxOpenConns(1)
+	}
+	return conn, nil
+}
+
+/**
+ * 开启数据库事务，事务会被封装为一个新的连接对象返回。
+ * 在事务连接上再次调用Begin时会创建保存点作为嵌套事务，嵌套事务的Rollback回滚到保存点，Commit释放保存点，
+ * 这样可复用的Service方法无论调用方是否已经开启事务，都可以按事务方式编写
  */
 func (this *GormProxy) Begin() (*GormProxy, error) {
 	if this.Conn == nil {
 		return nil, errors.New("begin transaction failed. db conn is nil")
 	}
+	if this.inTx {
+		return this.beginNested()
+	}
 	tx := this.Conn.Begin()
 	if tx.Error != nil {
-		return nil, fmt.Errorf("gorm: begin transaction failed.error %s", tx.Error.Error())
+		return nil, fmt.Errorf("gorm: begin transaction failed. error:%w", tx.Error)
 	}
 	proxyInTx := new(GormProxy)
 	proxyInTx.Conn = tx
 	proxyInTx.inTx = true
+	proxyInTx.txDepth = 1
+	proxyInTx.root = this.GetRoot()
 	return proxyInTx, nil
 }
 
 /**
- * 提交数据库事务
+ * 在当前事务中通过保存点开启嵌套事务。保存点按最外层事务内的序号命名，
+ * 同一层事务中先后开启多个未结束的嵌套事务时，各自回滚到自己的保存点
  */
-func (this *GormProxy) Commit() error {
-	if !this.inTx {
-		return fmt.Errorf("grom: transaction is not opened")
+func (this *GormProxy) beginNested() (*GormProxy, error) {
+	if this.txFinished {
+		return nil, errors.New("gorm: begin nested transaction failed. transaction has already been finished")
 	}
-	err := this.Conn.Commit().Error
+	depth := this.txDepth + 1
+	outerTx := this.getOuterTx()
+	outerTx.savepointSeq++
+	savepoint := fmt.Sprintf("sp_%d_%d", depth, outerTx.savepointSeq)
+	err := this.SavePoint(savepoint)
 	if err != nil {
-		return fmt.Errorf("gorm: transaction commit failed. error:%s", err.Error())
+		return nil, err
 	}
-	return nil
+	nestedTx := new(GormProxy)
+	nestedTx.Conn = this.Conn
+	nestedTx.inTx = true
+	nestedTx.txDepth = depth
+	nestedTx.savepoint = savepoint
+	nestedTx.root = this.GetRoot()
+	nestedTx.outerTx = outerTx
+	nestedTx.parentTx = this
+	return nestedTx, nil
 }
 
 /**
- * 回滚数据库事务
+ * 获取最外层事务，最外层事务返回自身
  */
-func (this *GormProxy) Rollback() error {
-	if !this.inTx {
-		return fmt.Errorf("grom: transaction is not opened")
+func (this *GormProxy) getOuterTx() *GormProxy {
+	if this.outerTx != nil {
+		return this.outerTx
 	}
-	err := this.Conn.Rollback().Error
-	if err != nil {
-		return fmt.Errorf("grom: transaction rollback failed. error:%s", err.Error())
+	return this
+}
+
+/**
+ * 判断当前连接是否处于未结束的事务中
+ */
+func (this *GormProxy) IsInTx() bool {
+	return this.inTx && !this.txFinished
+}
+
+/**
+ * 获取事务嵌套深度：0为非事务连接，1为最外层事务，大于1为通过保存点嵌套的事务
+ */
+func (this *GormProxy) GetTxDepth() int {
+	return this.txDepth
+}
+
+/**
+ * 获取事务连接所属的非事务连接，非事务连接返回自身
+ */
+func (this *GormProxy) GetRoot() *GormProxy {
+	if this.root != nil {
+		return this.root
 	}
-	return nil
+	return this
 }
 
 /**
- * 判断是否是未查询到数据错误（单独判断这个错误是因为查询不到数据不应该归为Error）
+ * 将事务标记为只能回滚，用于加入事务的一方无法直接回滚外层事务的场景，标记后Commit会回滚事务并返回错误
  */
-func (this *GormProxy) IsNoRowError(err error) bool {
-	return "sql: no rows in result set" == err.Error()
+func (this *GormProxy) SetRollbackOnly() {
+	this.getOuterTx().rollbackOnly = true
 }
 
 /**
- * 向数据库中批量插入数据，由于gorm不支持批量插入，因此手工实现本方法
- * 1、数组中要保证都是Struct
- * 2、要保证struct的类型一致
- * 3、保证指针和实例不能混用
- * 4、根据某一个struct反射insert语句（包括单个struct占位符的数量）
- * 5、根据数组元素数量生成占位符
- * 6、数组长度如果过长，要分批插入，比如是1000条/次
+ * 判断事务是否已被标记为只能回滚
  */
-func (this *GormProxy) BatchInsert(data interface{}) error {
-	//判断data不能为空
-	if data == nil {
-		panic("param data is nil")
-	}
-	t := reflect.TypeOf(data)
-	v := reflect.ValueOf(data)
-	//判断data的类型必须为slice
-	if t.Kind() != reflect.Slice {
-		panic(fmt.Sprintf("data[type kind:%s] is not reflect.Slice", t.Kind()))
-	}
-	//判断data数组中传入的类型是struct还是ptr
-	var isPtr bool
-	switch t.Elem().Kind() {
-	case reflect.Ptr:
-		isPtr = true
-	case reflect.Struct:
-		isPtr = false
-	default:
-		panic(fmt.Sprintf("data value[%s] must be struct or struct ptr", t.Elem().Kind()))
+func (this *GormProxy) IsRollbackOnly() bool {
+	return this.getOuterTx().rollbackOnly
+}
+
+/**
+ * 注册事务提交成功后执行的回调，用于在数据真正落库后发送通知、清理缓存等。
+ * 嵌套事务的回调在最外层事务提交后才会执行；非事务连接上注册的回调会立即执行
+ */
+func (this *GormProxy) OnCommit(callback func()) {
+	if !this.IsInTx() {
+		runTxCallbacks("commit", []func(){callback})
+		return
 	}
-	//如果数组长度为0则直接返回
-	if v.Len() == 0 {
-		return nil
+	this.onCommit = append(this.onCommit, callback)
+}
+
+/**
+ * 注册事务回滚后执行的回调。嵌套事务回滚到保存点时执行其自身注册的回调，
+ * 提交后则交由上一层事务在回滚时执行；非事务连接上注册的回调会被忽略
+ */
+func (this *GormProxy) OnRollback(callback func()) {
+	if !this.IsInTx() {
+		return
 	}
-	// 确定table_name
-	tableName := getTableName(t, v)
-	if tableName == "" {
-		return fmt.Errorf("gorm: can't get table name")
-	}
-	//反射结构体中的字段为一个map[field] = reflect.Kind
-	fieldMap := getStructField(t, isPtr)
-	//获取table中的列
-	tableFieldList := make([]string, 0)
-	for _, field := range fieldMap {
-		tableFieldList = append(tableFieldList, fmt.Sprintf("`%s`", camelToSnack(field)))
-	}
-	tableField := strings.Join(tableFieldList, ",")
-	//将tableName和field拼接在一起作为sql语句的前半部分
-	sql := fmt.Sprintf("insert into %s (%s) values ", tableName, tableField)
-	//将sql语句拼接成 insert into {table_name} (field...) values (...),(...),(...)...的形式批量插入
-	var count int
-	sqlValues := make([]string, 0, 0)
-	argValues := make([]interface{}, 0, 0)
-	valueMapSlice := getStructValueMap(v, isPtr)
-	for _, fieldValueMap := range valueMapSlice {
-		count++
-		//组装strVar => (?,?...?,?)
-		strVar := make([]byte, 0)
-		strVar = append(strVar, ' ', '(')
-		for _, field := range fieldMap {
-			strVar = append(strVar, '?', ',')
-			argValues = append(argValues, (*fieldValueMap)[field])
-		}
-		strVar = strVar[0 : len(strVar)-1]
-		strVar = append(strVar, ')', ' ')
-		sqlValues = append(sqlValues, string(strVar))
-		if count%500 == 0 {
-			//每次批量500个插入
-			err := this.Conn.Exec(sql+strings.Join(sqlValues, ","), argValues...).Error
-			if err != nil {
-				return fmt.Errorf("gorm: write data to database failed.%s\n", err)
-			}
-			count = 0
-			sqlValues = make([]string, 0, 0)
-			argValues = make([]interface{}, 0, 0)
-		}
+	this.onRollback = append(this.onRollback, callback)
+}
+
+/**
+ * 事务结束后执行回调：committed为true时执行提交回调，否则执行回滚回调
+ */
+func (this *GormProxy) finishCallbacks(committed bool) {
+	onCommit, onRollback := this.onCommit, this.onRollback
+	this.onCommit, this.onRollback = nil, nil
+	if committed {
+		runTxCallbacks("commit", onCommit)
+	} else {
+		runTxCallbacks("rollback", onRollback)
 	}
-	//将不足500的余下的数据一次性写入数据库
-	if len(argValues) == 0 {
-		return nil
+}
+
+/**
+ * 嵌套事务释放保存点后，其回调的执行时机由上一层事务决定
+ */
+func (this *GormProxy) handOverCallbacks() {
+	this.parentTx.onCommit = append(this.parentTx.onCommit, this.onCommit...)
+	this.parentTx.onRollback = append(this.parentTx.onRollback, this.onRollback...)
+	this.onCommit, this.onRollback = nil, nil
+}
+
+/**
+ * 依次执行事务回调，单个回调的panic会被捕获并记录，不影响其它回调的执行
+ */
+func runTxCallbacks(event string, callbacks []func()) {
+	for _, callback := range callbacks {
+		func() {
+			defer func() {
+				err := recover()
+				if err != nil {
+					logger.Error("gorm: transaction %s callback panic: %v", event, err)
+				}
+			}()
+			callback()
+		}()
+	}
+}
+
+/**
+ * 在当前事务中创建保存点
+ */
+func (this *GormProxy) SavePoint(name string) error {
+	if !this.inTx {
+		return fmt.Errorf("gorm: create savepoint failed. transaction is not opened")
 	}
-	err := this.Conn.Exec(sql+strings.Join(sqlValues, ","), argValues...).Error
+	err := this.Conn.SavePoint(name).Error
 	if err != nil {
-		return fmt.Errorf("gorm: write data to database failed.%s\n", err)
+		return fmt.Errorf("gorm: create savepoint %s failed. error:%w", name, err)
 	}
 	return nil
 }
 
 /**
- * 将驼峰转换为下划线命名的方法
+ * 回滚到指定的保存点
  */
-func camelToSnack(old string) string {
-	regex, _ := regexp.Compile("[A-Z]")
-	return regex.ReplaceAllStringFunc(old, func(str string) string {
-		return "_" + strings.ToLower(str)
-	})[1:]
+func (this *GormProxy) RollbackToSavePoint(name string) error {
+	if !this.inTx {
+		return fmt.Errorf("gorm: rollback to savepoint failed. transaction is not opened")
+	}
+	err := this.Conn.RollbackTo(name).Error
+	if err != nil {
+		return fmt.Errorf("gorm: rollback to savepoint %s failed. error:%w", name, err)
+	}
+	return nil
 }
 
 /**
- * 获取表名
+ * 释放指定的保存点
  */
-func getTableName(t reflect.Type, v reflect.Value) string {
-	// 如果有TableName函数，则调用TableName函数的返回值作为表名，否则表名是结构体对应的名字
-	f, ok := t.Elem().MethodByName("TableName")
-	var tableName string
-	if ok {
-		tableName = f.Func.Call([]reflect.Value{v.Index(0)})[0].String()
-	} else {
-		tableName = t.Elem().String()
-		tableName = camelToSnack(tableName[strings.LastIndex(tableName, ".")+1:])
+func (this *GormProxy) ReleaseSavePoint(name string) error {
+	if !this.inTx {
+		return fmt.Errorf("gorm: release savepoint failed. transaction is not opened")
 	}
-	return tableName
+	err := this.Conn.Exec("RELEASE SAVEPOINT " + name).Error
+	if err != nil {
+		return fmt.Errorf("gorm: release savepoint %s failed. error:%w", name, err)
+	}
+	return nil
 }
 
 /**
- * 获取某个结构的字段
+ * 提交数据库事务，嵌套事务则释放其保存点
  */
-func getStructField(t reflect.Type, isPtr bool) []string {
-	//转换structType直至为非ptr类型
-	var structType reflect.Type
-	if isPtr {
-		structType = t.Elem().Elem()
-	} else {
-		structType = t.Elem()
+func (this *GormProxy) Commit() error {
+	if !this.inTx {
+		return fmt.Errorf("grom: transaction is not opened")
+	}
+	if this.txFinished {
+		return fmt.Errorf("gorm: transaction has already been finished")
 	}
-	fieldMap := make([]string, 0)
-	for i := 0; i < structType.NumField(); i++ {
-		fieldMap = append(fieldMap, structType.Field(i).Name)
+	this.txFinished = true
+	if this.savepoint != "" {
+		err := this.ReleaseSavePoint(this.savepoint)
+		if err != nil {
+			this.finishCallbacks(false)
+			return err
+		}
+		this.handOverCallbacks()
+		return nil
+	}
+	if this.rollbackOnly {
+		err := this.Conn.Rollback().Error
+		this.finishCallbacks(false)
+		if err != nil {
+			return fmt.Errorf("gorm: transaction is rollback-only and rollback failed. error:%w", err)
+		}
+		return fmt.Errorf("gorm: transaction is marked rollback-only and has been rolled back")
+	}
+	err := this.Conn.Commit().Error
+	if err != nil {
+		this.finishCallbacks(false)
+		return fmt.Errorf("gorm: transaction commit failed. error:%w", err)
 	}
-	return fieldMap
+	this.finishCallbacks(true)
+	return nil
 }
 
 /**
- * 将结构体的field映射为一个map
+ * 回滚数据库事务，嵌套事务则回滚到其保存点
  */
-func getStructValueMap(v reflect.Value, isPtr bool) []*map[string]interface{} {
-	fieldMapSlice := make([]*map[string]interface{}, 0)
-	for i := 0; i < v.Len(); i++ {
-		var structValue reflect.Value
-		if isPtr {
-			structValue = v.Index(i).Elem()
-		} else {
-			structValue = v.Index(i)
-		}
-		fieldMap := make(map[string]interface{}, 0)
-		for j := 0; j < structValue.NumField(); j++ {
-			var (
-				mapKey   = structValue.Type().Field(j).Name
-				mapValue interface{}
-			)
-			if mapKey == "CreateTime" {
-				mapValue = time.Now()
-			} else {
-				mapValue = structValue.FieldByName(mapKey).Interface()
-			}
-			fieldMap[mapKey] = mapValue
-		}
-		fieldMapSlice = append(fieldMapSlice, &fieldMap)
+func (this *GormProxy) Rollback() error {
+	if !this.inTx {
+		return fmt.Errorf("grom: transaction is not opened")
+	}
+	if this.txFinished {
+		return fmt.Errorf("gorm: transaction has already been finished")
 	}
-	return fieldMapSlice
+	this.txFinished = true
+	defer this.finishCallbacks(false)
+	if this.savepoint != "" {
+		return this.RollbackToSavePoint(this.savepoint)
+	}
+	err := this.Conn.Rollback().Error
+	if err != nil {
+		return fmt.Errorf("grom: transaction rollback failed. error:%w", err)
+	}
+	return nil
 }
 
 /**
- * 关闭数据库连接（更换gorm.io后Close()方法不被支持）
+ * 判断是否是未查询到数据错误（单独判断这个错误是因为查询不到数据不应该归为Error），同时识别gorm.ErrRecordNotFound及sql.ErrNoRows
  */
-//func (this *GormProxy) Close() error {
-//	err := this.Conn.Close()
-//	if err != nil {
-//		return fmt.Errorf("gorm: close database failed.error:%s", err.Error())
-//	}
-//	return nil
-//}
+func (this *GormProxy) IsNoRowError(err error) bool {
+	return IsNotFoundError(err)
+}
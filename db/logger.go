@@ -0,0 +1,14 @@
+package db
+
+import (
+	log "github.com/duhaifeng/loglet"
+)
+
+var logger = log.NewLogger()
+
+/**
+ * 设置数据库层与外层应用使用统一的日志器
+ */
+func SetLogger(outerLogger *log.Logger) {
+	logger = outerLogger
+}
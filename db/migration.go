@@ -0,0 +1,441 @@
+package db
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+/**
+ * 默认的迁移历史表名
+ */
+const DEFAULT_MIGRATION_TABLE = "schema_migrations"
+
+/**
+ * 迁移SQL文件的命名格式：<版本号>_<名称>.up.sql 及 <版本号>_<名称>.down.sql，例如 20260101120000_create_user.up.sql
+ */
+var migrationFilePattern = regexp.MustCompile(`^(\d+)_(.+)\.(up|down)\.sql$`)
+
+/**
+ * 一个版本的数据库迁移，Up升级，Down回滚（为nil时该版本不能回滚）
+ */
+type Migration struct {
+	Version int64
+	Name    string
+	Up      func(tx *GormProxy) error
+	Down    func(tx *GormProxy) error
+}
+
+/**
+ * 迁移历史表的记录
+ */
+type migrationRecord struct {
+	Version   int64  `gorm:"primaryKey;autoIncrement:false"`
+	Name      string `gorm:"size:255"`
+	AppliedAt time.Time
+}
+
+/**
+ * 一个版本的迁移状态，Missing表示历史表中已执行但当前程序中没有注册的版本
+ */
+type MigrationStatus struct {
+	Version   int64
+	Name      string
+	Applied   bool
+	AppliedAt time.Time
+	Missing   bool
+}
+
+/**
+ * 数据库迁移管理器，在GormProxy对应的数据库中以历史表记录已执行的版本。
+ * 每个版本在独立的事务中执行并记录历史，注意MySQL的DDL语句会隐式提交事务，失败时可能需要人工处理
+ */
+type Migrator struct {
+	conn       *GormProxy
+	table      string
+	migrations map[int64]*Migration
+}
+
+/**
+ * 创建数据库迁移管理器
+ */
+func NewMigrator(conn *GormProxy) *Migrator {
+	return &Migrator{conn: conn, table: DEFAULT_MIGRATION_TABLE, migrations: make(map[int64]*Migration)}
+}
+
+/**
+ * 设置迁移历史表名
+ */
+func (this *Migrator) SetTable(table string) {
+	this.table = table
+}
+
+/**
+ * 注册一个Go函数实现的迁移，版本号重复时返回错误
+ */
+func (this *Migrator) Register(migration *Migration) error {
+	if migration.Up == nil {
+		return fmt.Errorf("migration %d has no up function", migration.Version)
+	}
+	if _, ok := this.migrations[migration.Version]; ok {
+		return fmt.Errorf("migration %d is registered repeatedly", migration.Version)
+	}
+	this.migrations[migration.Version] = migration
+	return nil
+}
+
+/**
+ * 注册一个SQL实现的迁移，downSql为空时该版本不能回滚。多条语句以行尾的分号分隔
+ */
+func (this *Migrator) RegisterSql(version int64, name, upSql, downSql string) error {
+	migration := &Migration{Version: version, Name: name, Up: sqlMigrationFunc(upSql)}
+	if strings.TrimSpace(downSql) != "" {
+		migration.Down = sqlMigrationFunc(downSql)
+	}
+	return this.Register(migration)
+}
+
+/**
+ * 从文件系统（通常为//go:embed嵌入的embed.FS）的dir目录加载SQL迁移文件，文件命名见migrationFilePattern，
+ * 不符合命名格式的文件被忽略，只有down文件没有up文件时返回错误
+ */
+func (this *Migrator) LoadSqlFiles(fsys fs.FS, dir string) error {
+	entries, err := fs.ReadDir(fsys, dir)
+	if err != nil {
+		return fmt.Errorf("read migration dir %s failed. %w", dir, err)
+	}
+	type sqlPair struct {
+		name     string
+		up, down string
+	}
+	pairs := make(map[int64]*sqlPair)
+	for _, entry := range entries {
+		matches := migrationFilePattern.FindStringSubmatch(entry.Name())
+		if entry.IsDir() || matches == nil {
+			continue
+		}
+		version, err := strconv.ParseInt(matches[1], 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid migration version of %s", entry.Name())
+		}
+		content, err := fs.ReadFile(fsys, path.Join(dir, entry.Name()))
+		if err != nil {
+			return fmt.Errorf("read migration file %s failed. %w", entry.Name(), err)
+		}
+		pair, ok := pairs[version]
+		if !ok {
+			pair = &sqlPair{name: matches[2]}
+			pairs[version] = pair
+		}
+		if pair.name != matches[2] {
+			return fmt.Errorf("migration %d has different names: %s, %s", version, pair.name, matches[2])
+		}
+		if matches[3] == "up" {
+			pair.up = string(content)
+		} else {
+			pair.down = string(content)
+		}
+	}
+	for version, pair := range pairs {
+		if strings.TrimSpace(pair.up) == "" {
+			return fmt.Errorf("migration %d_%s has no up sql", version, pair.name)
+		}
+		err = this.RegisterSql(version, pair.name, pair.up, pair.down)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+/**
+ * 将SQL脚本转换为迁移函数，脚本按行尾的分号拆分为多条语句依次执行
+ */
+func sqlMigrationFunc(script string) func(tx *GormProxy) error {
+	statements := splitSqlStatements(script)
+	return func(tx *GormProxy) error {
+		for _, statement := range statements {
+			err := tx.Conn.Exec(statement).Error
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+/**
+ * 按行尾的分号拆分SQL脚本，忽略空语句及只有--注释的行
+ */
+func splitSqlStatements(script string) []string {
+	var statements []string
+	var builder strings.Builder
+	for _, line := range strings.Split(script, "\n") {
+		trimmed := strings.TrimSpace(line)
+		if builder.Len() == 0 && (trimmed == "" || strings.HasPrefix(trimmed, "--")) {
+			continue
+		}
+		builder.WriteString(line)
+		builder.WriteString("\n")
+		if strings.HasSuffix(trimmed, ";") {
+			statements = append(statements, strings.TrimSpace(builder.String()))
+			builder.Reset()
+		}
+	}
+	if strings.TrimSpace(builder.String()) != "" {
+		statements = append(statements, strings.TrimSpace(builder.String()))
+	}
+	return statements
+}
+
+/**
+ * 按版本号排序的已注册迁移
+ */
+func (this *Migrator) sortedMigrations() []*Migration {
+	migrations := make([]*Migration, 0, len(this.migrations))
+	for _, migration := range this.migrations {
+		migrations = append(migrations, migration)
+	}
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].Version < migrations[j].Version
+	})
+	return migrations
+}
+
+/**
+ * 检查迁移管理器的数据库连接
+ */
+func (this *Migrator) checkConn() error {
+	if this.conn == nil || this.conn.Conn == nil {
+		return errors.New("migration failed. db conn is nil")
+	}
+	return nil
+}
+
+/**
+ * 创建迁移历史表（已存在时不做处理）
+ */
+func (this *Migrator) ensureTable() error {
+	err := this.checkConn()
+	if err != nil {
+		return err
+	}
+	err = this.conn.Conn.Table(this.table).AutoMigrate(new(migrationRecord))
+	if err != nil {
+		return fmt.Errorf("create migration table %s failed. %w", this.table, err)
+	}
+	return nil
+}
+
+/**
+ * 读取已执行的迁移记录，历史表不存在时视为没有执行过任何迁移，只读取不创建历史表
+ */
+func (this *Migrator) appliedRecords() (map[int64]*migrationRecord, error) {
+	err := this.checkConn()
+	if err != nil {
+		return nil, err
+	}
+	if !this.conn.Conn.Migrator().HasTable(this.table) {
+		return make(map[int64]*migrationRecord), nil
+	}
+	var records []*migrationRecord
+	err = this.conn.Conn.Table(this.table).Order("version").Find(&records).Error
+	if err != nil {
+		return nil, fmt.Errorf("read migration table %s failed. %w", this.table, err)
+	}
+	applied := make(map[int64]*migrationRecord, len(records))
+	for _, record := range records {
+		applied[record.Version] = record
+	}
+	return applied, nil
+}
+
+/**
+ * 获取尚未执行的迁移，按版本号排序。历史表不存在时所有迁移都未执行，检查不会在数据库中创建历史表
+ */
+func (this *Migrator) Pending() ([]*Migration, error) {
+	applied, err := this.appliedRecords()
+	if err != nil {
+		return nil, err
+	}
+	var pending []*Migration
+	for _, migration := range this.sortedMigrations() {
+		if _, ok := applied[migration.Version]; !ok {
+			pending = append(pending, migration)
+		}
+	}
+	return pending, nil
+}
+
+/**
+ * 执行所有尚未执行的迁移，返回本次执行的迁移。某个版本失败时停止，之前成功的版本保留
+ */
+func (this *Migrator) Up() ([]*Migration, error) {
+	return this.UpTo(0)
+}
+
+/**
+ * 执行版本号不大于version的所有尚未执行的迁移，version为0时执行全部
+ */
+func (this *Migrator) UpTo(version int64) ([]*Migration, error) {
+	err := this.ensureTable()
+	if err != nil {
+		return nil, err
+	}
+	pending, err := this.Pending()
+	if err != nil {
+		return nil, err
+	}
+	var done []*Migration
+	for _, migration := range pending {
+		if version > 0 && migration.Version > version {
+			break
+		}
+		err = this.conn.runInTx("", func(tx *GormProxy) error {
+			err := migration.Up(tx)
+			if err != nil {
+				return err
+			}
+			record := &migrationRecord{Version: migration.Version, Name: migration.Name, AppliedAt: time.Now()}
+			return tx.Conn.Table(this.table).Create(record).Error
+		})
+		if err != nil {
+			return done, fmt.Errorf("apply migration %d_%s failed. %w", migration.Version, migration.Name, err)
+		}
+		logger.Info("migration %d_%s applied", migration.Version, migration.Name)
+		done = append(done, migration)
+	}
+	return done, nil
+}
+
+/**
+ * 按版本号从新到旧回滚最近执行的steps个迁移，返回本次回滚的迁移。
+ * 已执行的版本没有注册或没有Down函数时返回错误，不再继续回滚
+ */
+func (this *Migrator) Down(steps int) ([]*Migration, error) {
+	applied, err := this.appliedRecords()
+	if err != nil {
+		return nil, err
+	}
+	versions := make([]int64, 0, len(applied))
+	for version := range applied {
+		versions = append(versions, version)
+	}
+	sort.Slice(versions, func(i, j int) bool {
+		return versions[i] > versions[j]
+	})
+	var done []*Migration
+	for i := 0; i < steps && i < len(versions); i++ {
+		migration, ok := this.migrations[versions[i]]
+		if !ok {
+			return done, fmt.Errorf("rollback migration %d failed. it is not registered", versions[i])
+		}
+		if migration.Down == nil {
+			return done, fmt.Errorf("rollback migration %d_%s failed. it has no down function", migration.Version, migration.Name)
+		}
+		err = this.conn.runInTx("", func(tx *GormProxy) error {
+			err := migration.Down(tx)
+			if err != nil {
+				return err
+			}
+			return tx.Conn.Table(this.table).Where("version = ?", migration.Version).Delete(new(migrationRecord)).Error
+		})
+		if err != nil {
+			return done, fmt.Errorf("rollback migration %d_%s failed. %w", migration.Version, migration.Name, err)
+		}
+		logger.Info("migration %d_%s rolled back", migration.Version, migration.Name)
+		done = append(done, migration)
+	}
+	return done, nil
+}
+
+/**
+ * 获取所有迁移的状态，包括已注册的迁移及历史表中存在但没有注册的版本，按版本号排序
+ */
+func (this *Migrator) Status() ([]*MigrationStatus, error) {
+	applied, err := this.appliedRecords()
+	if err != nil {
+		return nil, err
+	}
+	var statuses []*MigrationStatus
+	for _, migration := range this.migrations {
+		status := &MigrationStatus{Version: migration.Version, Name: migration.Name}
+		if record, ok := applied[migration.Version]; ok {
+			status.Applied = true
+			status.AppliedAt = record.AppliedAt
+		}
+		statuses = append(statuses, status)
+	}
+	for version, record := range applied {
+		if _, ok := this.migrations[version]; !ok {
+			statuses = append(statuses, &MigrationStatus{Version: version, Name: record.Name, Applied: true, AppliedAt: record.AppliedAt, Missing: true})
+		}
+	}
+	sort.Slice(statuses, func(i, j int) bool {
+		return statuses[i].Version < statuses[j].Version
+	})
+	return statuses, nil
+}
+
+/**
+ * 执行迁移命令，便于在程序的子命令中使用：up [version]、down [steps]（默认1）、status，结果输出到w
+ */
+func (this *Migrator) RunCommand(args []string, w io.Writer) error {
+	if len(args) == 0 {
+		return errors.New("usage: up [version] | down [steps] | status")
+	}
+	var migrations []*Migration
+	var err error
+	switch args[0] {
+	case "up":
+		var version int64
+		if len(args) > 1 {
+			version, err = strconv.ParseInt(args[1], 10, 64)
+			if err != nil {
+				return fmt.Errorf("invalid migration version %s", args[1])
+			}
+		}
+		migrations, err = this.UpTo(version)
+		for _, migration := range migrations {
+			fmt.Fprintf(w, "applied %d_%s\n", migration.Version, migration.Name)
+		}
+	case "down":
+		steps := 1
+		if len(args) > 1 {
+			steps, err = strconv.Atoi(args[1])
+			if err != nil || steps < 1 {
+				return fmt.Errorf("invalid rollback steps %s", args[1])
+			}
+		}
+		migrations, err = this.Down(steps)
+		for _, migration := range migrations {
+			fmt.Fprintf(w, "rolled back %d_%s\n", migration.Version, migration.Name)
+		}
+	case "status":
+		var statuses []*MigrationStatus
+		statuses, err = this.Status()
+		for _, status := range statuses {
+			state := "pending"
+			if status.Missing {
+				state = "applied (missing)"
+			} else if status.Applied {
+				state = "applied"
+			}
+			appliedAt := ""
+			if status.Applied {
+				appliedAt = status.AppliedAt.Format("2006-01-02 15:04:05")
+			}
+			fmt.Fprintf(w, "%d\t%s\t%s\t%s\n", status.Version, status.Name, state, appliedAt)
+		}
+	default:
+		return fmt.Errorf("unknown migration command %s", args[0])
+	}
+	return err
+}
@@ -0,0 +1,50 @@
+package db
+
+import (
+	"reflect"
+	"testing"
+	"testing/fstest"
+)
+
+func TestSplitSqlStatements(t *testing.T) {
+	script := `
+-- create tables
+CREATE TABLE a (
+  id INT,
+  name VARCHAR(10) DEFAULT 'x;y'
+);
+
+INSERT INTO a VALUES (1, 'a');
+UPDATE a SET name = 'b'`
+	expected := []string{"CREATE TABLE a (\n  id INT,\n  name VARCHAR(10) DEFAULT 'x;y'\n);", "INSERT INTO a VALUES (1, 'a');", "UPDATE a SET name = 'b'"}
+	if statements := splitSqlStatements(script); !reflect.DeepEqual(statements, expected) {
+		t.Fatalf("unexpected statements %q", statements)
+	}
+}
+
+func TestLoadSqlFiles(t *testing.T) {
+	fsys := fstest.MapFS{
+		"migrations/1_create_user.up.sql":   {Data: []byte("CREATE TABLE user (id INT);")},
+		"migrations/1_create_user.down.sql": {Data: []byte("DROP TABLE user;")},
+		"migrations/2_add_index.up.sql":     {Data: []byte("CREATE INDEX idx ON user (id);")},
+		"migrations/README.md":              {Data: []byte("ignored")},
+	}
+	migrator := NewMigrator(nil)
+	err := migrator.LoadSqlFiles(fsys, "migrations")
+	if err != nil {
+		t.Fatal(err)
+	}
+	migrations := migrator.sortedMigrations()
+	if len(migrations) != 2 || migrations[0].Name != "create_user" || migrations[0].Down == nil || migrations[1].Down != nil {
+		t.Fatalf("unexpected migrations %+v", migrations)
+	}
+	if migrator.RegisterSql(2, "again", "SELECT 1", "") == nil {
+		t.Fatal("repeated version should be rejected")
+	}
+	if NewMigrator(nil).LoadSqlFiles(fstest.MapFS{"m/3_x.down.sql": {Data: []byte("SELECT 1")}}, "m") == nil {
+		t.Fatal("migration without up sql should be rejected")
+	}
+	if _, err = migrator.Pending(); err == nil {
+		t.Fatal("pending migrations without conn should fail")
+	}
+}
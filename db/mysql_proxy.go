@@ -1,49 +1,163 @@
 package db
 
 import (
+	"context"
 	"database/sql"
+	"errors"
 	"fmt"
+	"sync"
+	"time"
+
 	_ "github.com/go-sql-driver/mysql"
 )
 
 /**
- * 基于原始sql库的数据库连接封装
+ * 每个数据库连接默认缓存的预编译语句数量上限
+ */
+const DEFAULT_STMT_CACHE_SIZE = 200
+
+/**
+ * 基于原始sql库的数据库连接封装，事务连接与其所属的连接共享底层的sql.DB及预编译语句缓存
  */
 type MySqlProxy struct {
-	conn *sql.DB
-	tx   *sql.Tx
+	conn     *sql.DB
+	tx       *sql.Tx
+	stmts    *stmtCache
+	dialect  string //数据库方言，决定命名参数替换后的占位符
+	borrowed bool   //是否借用了其它连接（GormProxy）的连接池，借用的连接池由其所有者关闭
+}
+
+/**
+ * 预编译语句缓存，按SQL缓存在sql.DB上预编译的语句。缓存满后新的语句不再缓存，直接执行
+ */
+type stmtCache struct {
+	size  int
+	stmts map[string]*sql.Stmt
+	lock  sync.Mutex
+}
+
+/**
+ * 各sql.DB对应的预编译语句缓存，使同一数据库上创建的多个MySqlProxy共享缓存
+ */
+var stmtCaches sync.Map
+
+/**
+ * 获取sql.DB对应的预编译语句缓存
+ */
+func getStmtCache(conn *sql.DB) *stmtCache {
+	cache, _ := stmtCaches.LoadOrStore(conn, &stmtCache{size: DEFAULT_STMT_CACHE_SIZE, stmts: make(map[string]*sql.Stmt)})
+	return cache.(*stmtCache)
+}
+
+/**
+ * 关闭并移除sql.DB对应的预编译语句缓存
+ */
+func closeStmtCache(conn *sql.DB) {
+	cache, ok := stmtCaches.LoadAndDelete(conn)
+	if !ok {
+		return
+	}
+	stmts := cache.(*stmtCache)
+	stmts.lock.Lock()
+	defer stmts.lock.Unlock()
+	for _, stmt := range stmts.stmts {
+		stmt.Close()
+	}
+	stmts.stmts = make(map[string]*sql.Stmt)
+}
+
+/**
+ * 基于已打开的MySQL sql.DB创建连接封装
+ */
+func NewMySqlProxy(conn *sql.DB) *MySqlProxy {
+	return &MySqlProxy{conn: conn, stmts: getStmtCache(conn), dialect: DIALECT_MYSQL}
 }
 
 /**
  * 打开数据库连接
  */
 func (this *MySqlProxy) Open(host, port, user, pass, database string) error {
-	connStr := fmt.Sprintf("%s:%s@tcp(%s:%s)/%s?charset=utf8", user, pass, host, port, database)
-	mysqlConn, err := sql.Open("mysql", connStr)
+	return this.OpenWithOptions(host, port, user, pass, database, &ConnOptions{Charset: "utf8", Loc: time.UTC, DisableParseTime: true})
+}
+
+/**
+ * 按连接选项（DSN参数及连接池配置）打开数据库连接，options为nil时使用默认选项
+ */
+func (this *MySqlProxy) OpenWithOptions(host, port, user, pass, database string, options *ConnOptions) error {
+	if options == nil {
+		options = DefaultConnOptions()
+	}
+	mysqlConn, err := sql.Open("mysql", options.mysqlDSN(host, port, user, pass, database))
 	if err != nil {
 		return err
 	}
+	options.applyPool(mysqlConn)
 	err = mysqlConn.Ping()
 	if err != nil {
+		mysqlConn.Close()
 		return err
 	}
 	this.conn = mysqlConn
+	this.tx = nil
+	this.stmts = getStmtCache(mysqlConn)
+	this.dialect = DIALECT_MYSQL
+	this.borrowed = false
 	return nil
 }
 
+/**
+ * 获取底层的sql.DB
+ */
+func (this *MySqlProxy) DB() *sql.DB {
+	return this.conn
+}
+
+/**
+ * 设置预编译语句缓存的数量上限，为0时不再缓存新的语句
+ */
+func (this *MySqlProxy) SetStmtCacheSize(size int) {
+	this.stmts.lock.Lock()
+	defer this.stmts.lock.Unlock()
+	this.stmts.size = size
+}
+
 /**
  * 开启数据库事务，事务会被封装为一个新的连接对象返回
  */
 func (this *MySqlProxy) Begin() (*MySqlProxy, error) {
-	tx, err := this.conn.Begin()
+	return this.BeginTx(context.Background(), nil)
+}
+
+/**
+ * 按事务选项开启数据库事务，ctx结束时事务会被回滚
+ */
+func (this *MySqlProxy) BeginTx(ctx context.Context, opts *sql.TxOptions) (*MySqlProxy, error) {
+	if this.conn == nil {
+		return nil, errors.New("sql: db conn is nil")
+	}
+	if this.tx != nil {
+		return nil, errors.New("sql: transaction has already been opened")
+	}
+	tx, err := this.conn.BeginTx(ctx, opts)
 	if err != nil {
 		return nil, err
 	}
 	txProxy := new(MySqlProxy)
+	txProxy.conn = this.conn
 	txProxy.tx = tx
+	txProxy.stmts = this.stmts
+	txProxy.dialect = this.dialect
+	txProxy.borrowed = this.borrowed
 	return txProxy, nil
 }
 
+/**
+ * 判断是否为事务连接
+ */
+func (this *MySqlProxy) IsInTx() bool {
+	return this.tx != nil
+}
+
 /**
  * 提交数据库事务
  */
@@ -68,36 +182,205 @@ func (this *MySqlProxy) Rollback() error {
 	return err
 }
 
+/**
+ * 获取SQL对应的预编译语句（事务中为绑定到事务的语句），语句未缓存且无法缓存时返回nil
+ */
+func (this *MySqlProxy) stmt(ctx context.Context, query string) (*sql.Stmt, error) {
+	if this.stmts == nil {
+		return nil, nil
+	}
+	this.stmts.lock.Lock()
+	stmt, ok := this.stmts.stmts[query]
+	//事务中不预编译新的语句，避免在事务占用连接时再从连接池获取连接
+	if !ok && this.tx == nil && len(this.stmts.stmts) < this.stmts.size {
+		var err error
+		stmt, err = this.conn.PrepareContext(ctx, query)
+		if err != nil {
+			this.stmts.lock.Unlock()
+			return nil, err
+		}
+		this.stmts.stmts[query] = stmt
+	}
+	this.stmts.lock.Unlock()
+	if stmt == nil {
+		return nil, nil
+	}
+	if this.tx != nil {
+		return this.tx.StmtContext(ctx, stmt), nil
+	}
+	return stmt, nil
+}
+
 /**
  * 执行数据库查询语句
  */
 func (this *MySqlProxy) Query(query string, args ...interface{}) (*sql.Rows, error) {
+	return this.QueryContext(context.Background(), query, args...)
+}
+
+/**
+ * 在ctx的时限内执行数据库查询语句，语句会被预编译并缓存
+ */
+func (this *MySqlProxy) QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error) {
+	if this.conn == nil {
+		return nil, errors.New("sql: db conn is nil")
+	}
+	stmt, err := this.stmt(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	if stmt != nil {
+		return stmt.QueryContext(ctx, args...)
+	}
 	if this.tx != nil {
-		return this.tx.Query(query, args...)
+		return this.tx.QueryContext(ctx, query, args...)
 	}
-	return this.conn.Query(query, args...)
+	return this.conn.QueryContext(ctx, query, args...)
 }
 
 /**
  * 执行数据库非查询语句
  */
 func (this *MySqlProxy) Exec(query string, args ...interface{}) (sql.Result, error) {
+	return this.ExecContext(context.Background(), query, args...)
+}
+
+/**
+ * 在ctx的时限内执行数据库非查询语句，语句会被预编译并缓存
+ */
+func (this *MySqlProxy) ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	if this.conn == nil {
+		return nil, errors.New("sql: db conn is nil")
+	}
+	stmt, err := this.stmt(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	if stmt != nil {
+		return stmt.ExecContext(ctx, args...)
+	}
 	if this.tx != nil {
-		return this.tx.Exec(query, args...)
+		return this.tx.ExecContext(ctx, query, args...)
+	}
+	return this.conn.ExecContext(ctx, query, args...)
+}
+
+/**
+ * 以:name形式的命名参数执行非查询语句，arg为map[string]interface{}或结构体（按与结果扫描相同的规则匹配列名）
+ */
+func (this *MySqlProxy) NamedExec(query string, arg interface{}) (sql.Result, error) {
+	return this.NamedExecContext(context.Background(), query, arg)
+}
+
+/**
+ * 在ctx的时限内以命名参数执行非查询语句
+ */
+func (this *MySqlProxy) NamedExecContext(ctx context.Context, query string, arg interface{}) (sql.Result, error) {
+	boundQuery, args, err := bindNamed(this.dialect, query, arg)
+	if err != nil {
+		return nil, err
 	}
-	return this.conn.Exec(query, args...)
+	return this.ExecContext(ctx, boundQuery, args...)
 }
 
 /**
- * 判断是否是未查询到数据错误（单独判断这个错误是因为查询不到数据不应该归为Error）
+ * 以:name形式的命名参数执行查询语句
+ */
+func (this *MySqlProxy) NamedQuery(query string, arg interface{}) (*sql.Rows, error) {
+	return this.NamedQueryContext(context.Background(), query, arg)
+}
+
+/**
+ * 在ctx的时限内以命名参数执行查询语句
+ */
+func (this *MySqlProxy) NamedQueryContext(ctx context.Context, query string, arg interface{}) (*sql.Rows, error) {
+	boundQuery, args, err := bindNamed(this.dialect, query, arg)
+	if err != nil {
+		return nil, err
+	}
+	return this.QueryContext(ctx, boundQuery, args...)
+}
+
+/**
+ * 查询一条记录并扫描到dest（结构体指针或基本类型指针），没有记录时返回sql.ErrNoRows
+ */
+func (this *MySqlProxy) Get(dest interface{}, query string, args ...interface{}) error {
+	return this.GetContext(context.Background(), dest, query, args...)
+}
+
+/**
+ * 在ctx的时限内查询一条记录并扫描到dest
+ */
+func (this *MySqlProxy) GetContext(ctx context.Context, dest interface{}, query string, args ...interface{}) error {
+	rows, err := this.QueryContext(ctx, query, args...)
+	if err != nil {
+		return err
+	}
+	return ScanOne(rows, dest)
+}
+
+/**
+ * 查询多条记录并扫描到dest（结构体、结构体指针或基本类型切片的指针）
+ */
+func (this *MySqlProxy) Select(dest interface{}, query string, args ...interface{}) error {
+	return this.SelectContext(context.Background(), dest, query, args...)
+}
+
+/**
+ * 在ctx的时限内查询多条记录并扫描到dest
+ */
+func (this *MySqlProxy) SelectContext(ctx context.Context, dest interface{}, query string, args ...interface{}) error {
+	rows, err := this.QueryContext(ctx, query, args...)
+	if err != nil {
+		return err
+	}
+	return ScanAll(rows, dest)
+}
+
+/**
+ * 判断是否是未查询到数据错误（单独判断这个错误是因为查询不到数据不应该归为Error），同时识别gorm.ErrRecordNotFound及sql.ErrNoRows
  */
 func (this *MySqlProxy) IsNoRowError(err error) bool {
-	return "sql: no rows in result set" == err.Error()
+	return IsNotFoundError(err)
 }
 
 /**
- * 关闭数据库连接
+ * 关闭数据库连接及缓存的预编译语句，事务连接不能被关闭。通过GormProxy.SqlProxy获取的连接不做任何操作，由GormProxy负责关闭
  */
 func (this *MySqlProxy) Close() error {
+	if this.tx != nil {
+		return errors.New("sql: can not close a transaction conn")
+	}
+	if this.conn == nil || this.borrowed {
+		return nil
+	}
+	closeStmtCache(this.conn)
 	return this.conn.Close()
 }
+
+/**
+ * 获取与GormProxy共用底层连接的MySqlProxy，GormProxy在事务中时返回的MySqlProxy加入同一个事务，
+ * 用于在同一数据源上混合使用gorm与原生SQL。返回的MySqlProxy按GormProxy的方言替换命名参数，其Close不会关闭共用的连接池
+ */
+func (this *GormProxy) SqlProxy() (*MySqlProxy, error) {
+	if this.Conn == nil {
+		return nil, errors.New("sql: db conn is nil")
+	}
+	sqlDB, err := this.GetRoot().sqlDB()
+	if err != nil {
+		return nil, err
+	}
+	proxy := NewMySqlProxy(sqlDB)
+	if this.Conn.Dialector != nil {
+		proxy.dialect = this.Conn.Dialector.Name()
+	}
+	proxy.borrowed = true
+	if this.inTx {
+		tx, ok := this.Conn.Statement.ConnPool.(*sql.Tx)
+		if !ok {
+			return nil, fmt.Errorf("sql: unsupported transaction conn pool %T", this.Conn.Statement.ConnPool)
+		}
+		proxy.tx = tx
+	}
+	return proxy, nil
+}
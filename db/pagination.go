@@ -0,0 +1,302 @@
+package db
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"gorm.io/gorm/schema"
+)
+
+/**
+ * 分页参数在查询字符串中的名称及默认值
+ */
+const (
+	PAGE_PARAM        = "page"      //页码，从1开始
+	PAGE_SIZE_PARAM   = "page_size" //每页记录数
+	SORT_PARAM        = "sort"      //排序字段，多个字段以逗号分隔，字段前加-表示降序，例如sort=-created_at,name
+	CURSOR_PARAM      = "cursor"    //游标分页时上一页返回的next_cursor
+	DEFAULT_PAGE_SIZE = 20
+	MAX_PAGE_SIZE     = 1000
+)
+
+/**
+ * 一个排序字段
+ */
+type SortField struct {
+	Column string
+	Desc   bool
+}
+
+/**
+ * 分页及排序参数，通过ParsePagination从查询字符串绑定
+ */
+type Pagination struct {
+	Page     int
+	PageSize int
+	Sorts    []SortField
+	Cursor   string
+}
+
+/**
+ * 分页查询的标准响应结构。偏移分页时返回总数，游标分页时返回下一页的游标（没有下一页时为空）
+ */
+type PagedResult struct {
+	Items      interface{} `json:"items"`
+	Total      int64       `json:"total"`
+	Page       int         `json:"page,omitempty"`
+	PageSize   int         `json:"page_size"`
+	HasMore    bool        `json:"has_more"`
+	NextCursor string      `json:"next_cursor,omitempty"`
+}
+
+/**
+ * 从查询字符串绑定分页参数：sortFields为允许排序的参数名到列名的白名单，不在白名单中的排序字段返回错误；
+ * 请求未指定排序时使用defaultSort（格式同sort参数，可以为空）。page_size超过MAX_PAGE_SIZE时按MAX_PAGE_SIZE处理
+ */
+func ParsePagination(query url.Values, sortFields map[string]string, defaultSort string) (*Pagination, error) {
+	pagination := &Pagination{Page: 1, PageSize: DEFAULT_PAGE_SIZE, Cursor: query.Get(CURSOR_PARAM)}
+	var err error
+	if page := query.Get(PAGE_PARAM); page != "" {
+		pagination.Page, err = strconv.Atoi(page)
+		if err != nil || pagination.Page < 1 {
+			return nil, fmt.Errorf("invalid %s parameter: %s", PAGE_PARAM, page)
+		}
+	}
+	if pageSize := query.Get(PAGE_SIZE_PARAM); pageSize != "" {
+		pagination.PageSize, err = strconv.Atoi(pageSize)
+		if err != nil || pagination.PageSize < 1 {
+			return nil, fmt.Errorf("invalid %s parameter: %s", PAGE_SIZE_PARAM, pageSize)
+		}
+		if pagination.PageSize > MAX_PAGE_SIZE {
+			pagination.PageSize = MAX_PAGE_SIZE
+		}
+	}
+	sort := query.Get(SORT_PARAM)
+	if sort == "" {
+		sort = defaultSort
+	}
+	for _, name := range strings.Split(sort, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		sortField := SortField{}
+		if strings.HasPrefix(name, "-") {
+			sortField.Desc = true
+			name = name[1:]
+		} else if strings.HasPrefix(name, "+") {
+			name = name[1:]
+		}
+		column, ok := sortFields[name]
+		if !ok {
+			return nil, fmt.Errorf("sorting by %s is not allowed", name)
+		}
+		sortField.Column = column
+		pagination.Sorts = append(pagination.Sorts, sortField)
+	}
+	return pagination, nil
+}
+
+/**
+ * 获取偏移分页的偏移量
+ */
+func (this *Pagination) Offset() int {
+	return (this.Page - 1) * this.PageSize
+}
+
+/**
+ * 为查询追加排序条件
+ */
+func (this *Pagination) applySorts(query *gorm.DB) *gorm.DB {
+	for _, sort := range this.Sorts {
+		query = query.Order(clause.OrderByColumn{Column: clause.Column{Name: sort.Column}, Desc: sort.Desc})
+	}
+	return query
+}
+
+/**
+ * 按偏移分页执行查询：先统计满足条件的总数，再按排序查询当前页的记录到dest（结构体切片的指针）。
+ * conn为已经追加了查询条件的gorm查询，不需要调用Model
+ */
+func (this *Pagination) Paginate(conn *gorm.DB, dest interface{}) (*PagedResult, error) {
+	if conn.Statement.Model == nil {
+		conn = conn.Model(dest)
+	}
+	//统计及查询各自复制一份查询条件，互不影响
+	query := conn.Session(&gorm.Session{})
+	result := &PagedResult{Page: this.Page, PageSize: this.PageSize}
+	err := query.Count(&result.Total).Error
+	if err != nil {
+		return nil, fmt.Errorf("gorm: count paged query failed. %w", err)
+	}
+	if int64(this.Offset()) < result.Total {
+		err = this.applySorts(query).Offset(this.Offset()).Limit(this.PageSize).Find(dest).Error
+		if err != nil {
+			return nil, fmt.Errorf("gorm: find paged query failed. %w", err)
+		}
+	}
+	result.Items = dest
+	result.HasMore = int64(this.Offset()+this.PageSize) < result.Total
+	return result, nil
+}
+
+/**
+ * 按游标（keyset）分页执行查询：根据上一页最后一条记录的排序字段值定位当前页，不统计总数，也不使用OFFSET，
+ * 适用于大表的翻页。排序字段中不包含主键时自动追加主键以保证顺序唯一，Page参数被忽略
+ */
+func (this *Pagination) PaginateByCursor(conn *gorm.DB, dest interface{}) (*PagedResult, error) {
+	destVal := reflect.ValueOf(dest)
+	if destVal.Kind() != reflect.Ptr || destVal.Elem().Kind() != reflect.Slice {
+		return nil, fmt.Errorf("gorm: paged dest [%T] must be a slice ptr", dest)
+	}
+	elemType := destVal.Elem().Type().Elem()
+	for elemType.Kind() == reflect.Ptr {
+		elemType = elemType.Elem()
+	}
+	modelSchema, err := parseModelSchema(conn, elemType)
+	if err != nil {
+		return nil, err
+	}
+	sorts, fields, err := this.keysetSorts(modelSchema)
+	if err != nil {
+		return nil, err
+	}
+	query := conn.Session(&gorm.Session{})
+	if this.Cursor != "" {
+		values, err := decodeCursor(this.Cursor, fields)
+		if err != nil {
+			return nil, err
+		}
+		query = query.Where(keysetCondition(sorts, values))
+	}
+	for _, sort := range sorts {
+		query = query.Order(clause.OrderByColumn{Column: clause.Column{Name: sort.Column}, Desc: sort.Desc})
+	}
+	//多查询一条记录用于判断是否还有下一页
+	err = query.Limit(this.PageSize + 1).Find(dest).Error
+	if err != nil {
+		return nil, fmt.Errorf("gorm: find paged query failed. %w", err)
+	}
+	result := &PagedResult{Items: dest, PageSize: this.PageSize}
+	rows := destVal.Elem()
+	if rows.Len() > this.PageSize {
+		rows.Set(rows.Slice(0, this.PageSize))
+		result.HasMore = true
+		result.NextCursor, err = encodeCursor(reflect.Indirect(rows.Index(this.PageSize-1)), fields)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return result, nil
+}
+
+/**
+ * 获取游标分页的排序字段及对应的Schema字段，排序字段中不包含主键时按最后一个排序字段的方向追加主键
+ */
+func (this *Pagination) keysetSorts(modelSchema *schema.Schema) ([]SortField, []*schema.Field, error) {
+	sorts := append([]SortField(nil), this.Sorts...)
+	primaryField := modelSchema.PrioritizedPrimaryField
+	hasPrimaryKey := false
+	for _, sort := range sorts {
+		if primaryField != nil && sort.Column == primaryField.DBName {
+			hasPrimaryKey = true
+		}
+	}
+	if !hasPrimaryKey {
+		if primaryField == nil {
+			return nil, nil, fmt.Errorf("gorm: %s has no primary key for cursor pagination", modelSchema.Name)
+		}
+		desc := len(sorts) > 0 && sorts[len(sorts)-1].Desc
+		sorts = append(sorts, SortField{Column: primaryField.DBName, Desc: desc})
+	}
+	fields := make([]*schema.Field, len(sorts))
+	for i, sort := range sorts {
+		fields[i] = modelSchema.LookUpField(sort.Column)
+		if fields[i] == nil || fields[i].DBName == "" {
+			return nil, nil, fmt.Errorf("gorm: sort column %s is not found in %s", sort.Column, modelSchema.Name)
+		}
+	}
+	return sorts, fields, nil
+}
+
+/**
+ * 生成位于游标之后的记录条件：(a > ?) OR (a = ? AND b > ?) OR ...，降序字段使用 <
+ */
+func keysetCondition(sorts []SortField, values []interface{}) clause.Expression {
+	var ors []clause.Expression
+	for i, sort := range sorts {
+		var ands []clause.Expression
+		for j := 0; j < i; j++ {
+			ands = append(ands, clause.Eq{Column: clause.Column{Name: sorts[j].Column}, Value: values[j]})
+		}
+		column := clause.Column{Name: sort.Column}
+		if sort.Desc {
+			ands = append(ands, clause.Lt{Column: column, Value: values[i]})
+		} else {
+			ands = append(ands, clause.Gt{Column: column, Value: values[i]})
+		}
+		ors = append(ors, clause.And(ands...))
+	}
+	return clause.Or(ors...)
+}
+
+/**
+ * 将记录的排序字段值编码为游标
+ */
+func encodeCursor(row reflect.Value, fields []*schema.Field) (string, error) {
+	values := make([]interface{}, len(fields))
+	for i, field := range fields {
+		values[i], _ = field.ValueOf(context.Background(), row)
+	}
+	data, err := json.Marshal(values)
+	if err != nil {
+		return "", fmt.Errorf("gorm: encode cursor failed. %w", err)
+	}
+	return base64.RawURLEncoding.EncodeToString(data), nil
+}
+
+/**
+ * 将游标解码为排序字段值，每个值按字段的类型解析，保证与数据库比较时类型一致
+ */
+func decodeCursor(cursor string, fields []*schema.Field) ([]interface{}, error) {
+	invalidErr := errors.New("invalid pagination cursor")
+	data, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return nil, invalidErr
+	}
+	var rawValues []json.RawMessage
+	err = json.Unmarshal(data, &rawValues)
+	if err != nil || len(rawValues) != len(fields) {
+		return nil, invalidErr
+	}
+	values := make([]interface{}, len(fields))
+	for i, field := range fields {
+		value := reflect.New(field.IndirectFieldType)
+		err = json.Unmarshal(rawValues[i], value.Interface())
+		if err != nil {
+			return nil, invalidErr
+		}
+		values[i] = value.Elem().Interface()
+	}
+	return values, nil
+}
+
+/**
+ * 按连接的命名策略解析结构体类型的Schema
+ */
+func parseModelSchema(conn *gorm.DB, modelType reflect.Type) (*schema.Schema, error) {
+	modelSchema, err := schema.Parse(reflect.New(modelType).Interface(), schemaCache, conn.NamingStrategy)
+	if err != nil {
+		return nil, fmt.Errorf("gorm: parse schema of %s failed. %w", modelType.String(), err)
+	}
+	return modelSchema, nil
+}
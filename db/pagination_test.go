@@ -0,0 +1,70 @@
+package db
+
+import (
+	"net/url"
+	"reflect"
+	"testing"
+	"time"
+
+	"gorm.io/gorm/clause"
+)
+
+type pageTestRow struct {
+	Id        int64
+	Name      string
+	CreatedAt time.Time
+}
+
+func TestParsePagination(t *testing.T) {
+	sortFields := map[string]string{"name": "name", "created": "created_at"}
+	query, _ := url.ParseQuery("page=3&page_size=5000&sort=-created,+name")
+	pagination, err := ParsePagination(query, sortFields, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectedSorts := []SortField{{Column: "created_at", Desc: true}, {Column: "name"}}
+	if pagination.Page != 3 || pagination.PageSize != MAX_PAGE_SIZE || !reflect.DeepEqual(pagination.Sorts, expectedSorts) {
+		t.Fatalf("unexpected pagination %+v", pagination)
+	}
+	if pagination.Offset() != 2*MAX_PAGE_SIZE {
+		t.Fatal("unexpected offset")
+	}
+	pagination, err = ParsePagination(url.Values{}, sortFields, "-name")
+	if err != nil || pagination.Page != 1 || pagination.PageSize != DEFAULT_PAGE_SIZE || pagination.Sorts[0] != (SortField{Column: "name", Desc: true}) {
+		t.Fatalf("default pagination is not expected: %v %+v", err, pagination)
+	}
+	for _, rawQuery := range []string{"page=0", "page=a", "page_size=-1", "sort=password"} {
+		query, _ = url.ParseQuery(rawQuery)
+		if _, err = ParsePagination(query, sortFields, ""); err == nil {
+			t.Errorf("%s should be rejected", rawQuery)
+		}
+	}
+}
+
+func TestPaginationCursor(t *testing.T) {
+	proxy := newBatchTestProxy()
+	modelSchema, _ := proxy.parseSchema(reflect.TypeOf(pageTestRow{}))
+	pagination := &Pagination{Sorts: []SortField{{Column: "created_at", Desc: true}}}
+	sorts, fields, err := pagination.keysetSorts(modelSchema)
+	if err != nil || len(sorts) != 2 || sorts[1] != (SortField{Column: "id", Desc: true}) {
+		t.Fatalf("primary key should be appended to sorts: %v %+v", err, sorts)
+	}
+	row := pageTestRow{Id: 1 << 60, CreatedAt: time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)}
+	cursor, err := encodeCursor(reflect.ValueOf(row), fields)
+	if err != nil {
+		t.Fatal(err)
+	}
+	values, err := decodeCursor(cursor, fields)
+	if err != nil || !values[0].(time.Time).Equal(row.CreatedAt) || values[1].(int64) != row.Id {
+		t.Fatalf("cursor values should keep field types: %v %v", err, values)
+	}
+	if _, err = decodeCursor("!"+cursor, fields); err == nil {
+		t.Fatal("invalid cursor should fail")
+	}
+	condition := keysetCondition(sorts, values)
+	expected := clause.Or(clause.And(clause.Lt{Column: clause.Column{Name: "created_at"}, Value: values[0]}),
+		clause.And(clause.Eq{Column: clause.Column{Name: "created_at"}, Value: values[0]}, clause.Lt{Column: clause.Column{Name: "id"}, Value: values[1]}))
+	if !reflect.DeepEqual(condition, expected) {
+		t.Fatalf("unexpected keyset condition %#v", condition)
+	}
+}
@@ -0,0 +1,14 @@
+/**
+ * PostgreSQL方言，导入本包后即可通过GormProxy.OpenPostgres打开PostgreSQL数据库：
+ * import _ "github.com/duhaifeng/simpleapi/db/postgres"
+ */
+package postgres
+
+import (
+	"github.com/duhaifeng/simpleapi/db"
+	"gorm.io/driver/postgres"
+)
+
+func init() {
+	db.RegisterDialect(db.DIALECT_POSTGRES, postgres.Open)
+}
@@ -0,0 +1,20 @@
+package postgres
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/duhaifeng/simpleapi/db"
+)
+
+func TestPostgresDialectRegistered(t *testing.T) {
+	proxy := new(db.GormProxy)
+	err := proxy.OpenPostgres("127.0.0.1", "1", "app", "pass", "orders")
+	if err == nil {
+		proxy.Close()
+		t.Fatal("no postgres server should listen on port 1")
+	}
+	if strings.Contains(err.Error(), "unknown db dialect") {
+		t.Fatalf("importing db/postgres should register the postgres dialect: %v", err)
+	}
+}
@@ -0,0 +1,262 @@
+package db
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+/**
+ * 从库的选择策略
+ */
+const (
+	REPLICA_POLICY_ROUND_ROBIN   = "round_robin"   //在健康的从库间轮询（默认）
+	REPLICA_POLICY_LEAST_LATENCY = "least_latency" //选择最近一次健康检查延迟最低的从库
+)
+
+/**
+ * 通过OpenMySQLReplica打开从库时自动启动的健康检查间隔
+ */
+const DEFAULT_REPLICA_CHECK_INTERVAL = 10 * time.Second
+
+/**
+ * 一个只读从库连接
+ */
+type replica struct {
+	conn    *gorm.DB
+	healthy int32 //1为健康，健康检查失败时置为0，不再被选择
+	latency int64 //最近一次健康检查的延迟（纳秒）
+}
+
+/**
+ * 主库连接关联的从库集合
+ */
+type replicaSet struct {
+	replicas  []*replica
+	policy    string
+	next      uint64 //轮询计数
+	lock      sync.Mutex
+	stopCheck chan struct{}
+}
+
+/**
+ * 为主库连接添加一个只读从库，事务外的读操作会被路由到健康的从库上
+ */
+func (this *GormProxy) AddReplica(conn *gorm.DB) {
+	set := this.initReplicas()
+	set.lock.Lock()
+	defer set.lock.Unlock()
+	//复制切片，使正在进行的选择不受影响
+	replicas := append(make([]*replica, 0, len(set.replicas)+1), set.replicas...)
+	set.replicas = append(replicas, &replica{conn: conn, healthy: 1})
+}
+
+/**
+ * 获取从库集合，未添加过从库时返回nil
+ */
+func (this *GormProxy) getReplicas() *replicaSet {
+	this.replicaLock.RLock()
+	defer this.replicaLock.RUnlock()
+	return this.replicas
+}
+
+/**
+ * 获取从库集合，不存在时创建一个使用默认轮询策略的集合
+ */
+func (this *GormProxy) initReplicas() *replicaSet {
+	this.replicaLock.Lock()
+	defer this.replicaLock.Unlock()
+	if this.replicas == nil {
+		this.replicas = &replicaSet{policy: REPLICA_POLICY_ROUND_ROBIN}
+	}
+	return this.replicas
+}
+
+/**
+ * 打开一个MySQL只读从库并添加到主库连接上，健康检查未启动时按DEFAULT_REPLICA_CHECK_INTERVAL启动
+ */
+func (this *GormProxy) OpenMySQLReplica(host, port, user, pass, database string) error {
+	options := this.options
+	if options == nil {
+		options = DefaultConnOptions()
+	}
+	conn, err := openGormConn("mysql", host, port, user, pass, database, options)
+	if err != nil {
+		return err
+	}
+	this.AddReplica(conn)
+	set := this.getReplicas()
+	set.lock.Lock()
+	checking := set.stopCheck != nil
+	set.lock.Unlock()
+	if !checking {
+		this.StartReplicaHealthCheck(DEFAULT_REPLICA_CHECK_INTERVAL)
+	}
+	return nil
+}
+
+/**
+ * 设置从库的选择策略，见REPLICA_POLICY_*定义
+ */
+func (this *GormProxy) SetReplicaPolicy(policy string) error {
+	if policy != REPLICA_POLICY_ROUND_ROBIN && policy != REPLICA_POLICY_LEAST_LATENCY {
+		return fmt.Errorf("unknown replica policy %s", policy)
+	}
+	set := this.initReplicas()
+	set.lock.Lock()
+	defer set.lock.Unlock()
+	set.policy = policy
+	return nil
+}
+
+/**
+ * 获取一个读操作强制使用主库的连接副本，用于写入后需要立即读到最新数据的场景
+ */
+func (this *GormProxy) ForcePrimary() *GormProxy {
+	if this.forcePrimary || this.inTx {
+		return this
+	}
+	return &GormProxy{Conn: this.Conn, replicas: this.getReplicas(), options: this.options, forcePrimary: true}
+}
+
+/**
+ * 获取执行写操作的连接，写操作总是使用主库（事务中为事务连接）
+ */
+func (this *GormProxy) WriteConn() *gorm.DB {
+	return this.Conn
+}
+
+/**
+ * 获取执行读操作的连接：事务中的读操作及强制使用主库时使用主库，否则选择一个健康的从库，没有可用的从库时使用主库
+ */
+func (this *GormProxy) ReadConn() *gorm.DB {
+	if this.inTx || this.forcePrimary {
+		return this.Conn
+	}
+	set := this.getReplicas()
+	if set == nil {
+		return this.Conn
+	}
+	replica := set.pick()
+	if replica == nil {
+		return this.Conn
+	}
+	return replica.conn
+}
+
+/**
+ * 按策略选择一个健康的从库，没有健康的从库时返回nil
+ */
+func (this *replicaSet) pick() *replica {
+	this.lock.Lock()
+	replicas := this.replicas
+	policy := this.policy
+	this.lock.Unlock()
+	if len(replicas) == 0 {
+		return nil
+	}
+	if policy == REPLICA_POLICY_LEAST_LATENCY {
+		var picked *replica
+		for _, replica := range replicas {
+			if atomic.LoadInt32(&replica.healthy) == 0 {
+				continue
+			}
+			if picked == nil || atomic.LoadInt64(&replica.latency) < atomic.LoadInt64(&picked.latency) {
+				picked = replica
+			}
+		}
+		return picked
+	}
+	start := atomic.AddUint64(&this.next, 1)
+	for i := 0; i < len(replicas); i++ {
+		replica := replicas[(start+uint64(i))%uint64(len(replicas))]
+		if atomic.LoadInt32(&replica.healthy) == 1 {
+			return replica
+		}
+	}
+	return nil
+}
+
+/**
+ * 对所有从库执行一次健康检查，记录延迟，检查失败的从库在恢复前不会再被选择
+ */
+func (this *GormProxy) CheckReplicaHealth() {
+	set := this.getReplicas()
+	if set == nil {
+		return
+	}
+	set.lock.Lock()
+	replicas := set.replicas
+	set.lock.Unlock()
+	for i, replica := range replicas {
+		start := time.Now()
+		err := pingGormConn(replica.conn)
+		if err != nil {
+			if atomic.SwapInt32(&replica.healthy, 0) == 1 {
+				logger.Warn("gorm: replica %d is unhealthy: %s", i, err.Error())
+			}
+			continue
+		}
+		atomic.StoreInt64(&replica.latency, int64(time.Since(start)))
+		if atomic.SwapInt32(&replica.healthy, 1) == 0 {
+			logger.Info("gorm: replica %d is recovered", i)
+		}
+	}
+}
+
+/**
+ * 启动后台定时健康检查，重复调用时先停止之前的检查
+ */
+func (this *GormProxy) StartReplicaHealthCheck(interval time.Duration) {
+	set := this.getReplicas()
+	if set == nil {
+		return
+	}
+	this.StopReplicaHealthCheck()
+	stopCheck := make(chan struct{})
+	set.lock.Lock()
+	set.stopCheck = stopCheck
+	set.lock.Unlock()
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				this.CheckReplicaHealth()
+			case <-stopCheck:
+				return
+			}
+		}
+	}()
+}
+
+/**
+ * 停止后台健康检查
+ */
+func (this *GormProxy) StopReplicaHealthCheck() {
+	set := this.getReplicas()
+	if set == nil {
+		return
+	}
+	set.lock.Lock()
+	defer set.lock.Unlock()
+	if set.stopCheck != nil {
+		close(set.stopCheck)
+		set.stopCheck = nil
+	}
+}
+
+/**
+ * 检查gorm连接是否可用
+ */
+func pingGormConn(conn *gorm.DB) error {
+	sqlDB, err := conn.DB()
+	if err != nil {
+		return err
+	}
+	return sqlDB.Ping()
+}
@@ -0,0 +1,83 @@
+package db
+
+import (
+	"sync"
+	"testing"
+
+	"gorm.io/gorm"
+)
+
+func TestReplicaRouting(t *testing.T) {
+	primary := &GormProxy{Conn: new(gorm.DB)}
+	if primary.ReadConn() != primary.Conn {
+		t.Fatal("reads should use primary when no replica is configured")
+	}
+	replicaA, replicaB := new(gorm.DB), new(gorm.DB)
+	primary.AddReplica(replicaA)
+	primary.AddReplica(replicaB)
+	first, second := primary.ReadConn(), primary.ReadConn()
+	if first == second || (first != replicaA && first != replicaB) || (second != replicaA && second != replicaB) {
+		t.Fatal("reads should be round-robin between replicas")
+	}
+	if primary.WriteConn() != primary.Conn || primary.ForcePrimary().ReadConn() != primary.Conn {
+		t.Fatal("writes and forced reads should use primary")
+	}
+	primary.replicas.replicas[0].healthy = 0
+	for i := 0; i < 3; i++ {
+		if primary.ReadConn() != replicaB {
+			t.Fatal("unhealthy replica should be skipped")
+		}
+	}
+	primary.replicas.replicas[1].healthy = 0
+	if primary.ReadConn() != primary.Conn {
+		t.Fatal("reads should fall back to primary when no replica is healthy")
+	}
+
+	primary.replicas.replicas[0].healthy = 1
+	primary.replicas.replicas[1].healthy = 1
+	primary.replicas.replicas[0].latency = 20
+	primary.replicas.replicas[1].latency = 10
+	//运行中修改策略不能与读操作的选择产生竞争
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 100; i++ {
+			primary.ReadConn()
+		}
+	}()
+	primary.SetReplicaPolicy(REPLICA_POLICY_LEAST_LATENCY)
+	<-done
+	if primary.ReadConn() != replicaB {
+		t.Fatal("least-latency policy should pick the fastest replica")
+	}
+
+	tx := &GormProxy{Conn: new(gorm.DB), inTx: true, txDepth: 1, root: primary}
+	if tx.ReadConn() != tx.Conn {
+		t.Fatal("reads in transaction should use the transaction")
+	}
+}
+
+func TestReplicaLazyInit(t *testing.T) {
+	primary := &GormProxy{Conn: new(gorm.DB)}
+	//并发添加从库、设置策略及读操作时只能创建一个从库集合
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(3)
+		go func() {
+			defer wg.Done()
+			primary.AddReplica(new(gorm.DB))
+		}()
+		go func() {
+			defer wg.Done()
+			primary.SetReplicaPolicy(REPLICA_POLICY_ROUND_ROBIN)
+		}()
+		go func() {
+			defer wg.Done()
+			primary.ReadConn()
+		}()
+	}
+	wg.Wait()
+	if len(primary.replicas.replicas) != 10 {
+		t.Fatalf("replicas added concurrently should all be kept: %d", len(primary.replicas.replicas))
+	}
+}
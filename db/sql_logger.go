@@ -0,0 +1,148 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"gorm.io/gorm"
+	gormlogger "gorm.io/gorm/logger"
+	"gorm.io/gorm/utils"
+)
+
+/**
+ * 默认的慢查询阈值
+ */
+const DEFAULT_SLOW_SQL_THRESHOLD = 200 * time.Millisecond
+
+/**
+ * SQL日志的开关状态，通过WithSqlLog设置到查询的context中，用于按路由开启或关闭SQL日志
+ */
+const (
+	SQL_LOG_DEFAULT = iota //按SqlLogger的配置记录
+	SQL_LOG_ON             //记录所有语句
+	SQL_LOG_OFF            //不记录语句及慢查询，执行出错时仍然记录
+)
+
+/**
+ * 查询context中携带的SQL日志信息
+ */
+type sqlLogContextKey struct{}
+
+type sqlLogContext struct {
+	reqId string
+	mode  int
+}
+
+/**
+ * 为查询的context附加请求ID及SQL日志开关，SQL日志以该请求ID作为前缀
+ */
+func WithSqlLog(ctx context.Context, reqId string, mode int) context.Context {
+	return context.WithValue(ctx, sqlLogContextKey{}, &sqlLogContext{reqId: reqId, mode: mode})
+}
+
+/**
+ * 获取context中的SQL日志信息
+ */
+func getSqlLogContext(ctx context.Context) *sqlLogContext {
+	if ctx != nil {
+		if logCtx, ok := ctx.Value(sqlLogContextKey{}).(*sqlLogContext); ok {
+			return logCtx
+		}
+	}
+	return &sqlLogContext{}
+}
+
+/**
+ * 通过包日志器输出gorm日志的适配器：执行出错的语句以ERROR记录，超过慢查询阈值的语句以WARN记录，
+ * 开启语句日志时（或通过gorm的Debug()）所有语句以DEBUG记录
+ */
+type SqlLogger struct {
+	SlowThreshold time.Duration //慢查询阈值，小于等于0时不记录慢查询
+	LogStatements bool          //是否记录所有语句
+}
+
+/**
+ * 按连接选项创建SQL日志适配器
+ */
+func (this *ConnOptions) sqlLogger() *SqlLogger {
+	sqlLogger := &SqlLogger{SlowThreshold: this.SlowThreshold, LogStatements: this.LogStatements}
+	if sqlLogger.SlowThreshold == 0 {
+		sqlLogger.SlowThreshold = DEFAULT_SLOW_SQL_THRESHOLD
+	}
+	return sqlLogger
+}
+
+/**
+ * 替换连接使用的gorm日志器，已经开启的事务连接不受影响
+ */
+func (this *GormProxy) SetSqlLogger(sqlLogger gormlogger.Interface) {
+	if this.Conn != nil {
+		this.Conn.Logger = sqlLogger
+	}
+}
+
+/**
+ * 实现gorm的日志接口，级别为Info时记录所有语句（gorm的Debug()会以此级别调用），Silent时只记录错误
+ */
+func (this *SqlLogger) LogMode(level gormlogger.LogLevel) gormlogger.Interface {
+	sqlLogger := *this
+	switch {
+	case level >= gormlogger.Info:
+		sqlLogger.LogStatements = true
+	case level <= gormlogger.Silent:
+		sqlLogger.LogStatements = false
+		sqlLogger.SlowThreshold = 0
+	}
+	return &sqlLogger
+}
+
+func (this *SqlLogger) Info(ctx context.Context, msg string, data ...interface{}) {
+	logger.Info("%s gorm: %s", getSqlLogContext(ctx).reqId, fmt.Sprintf(msg, data...))
+}
+
+func (this *SqlLogger) Warn(ctx context.Context, msg string, data ...interface{}) {
+	logger.Warn("%s gorm: %s", getSqlLogContext(ctx).reqId, fmt.Sprintf(msg, data...))
+}
+
+func (this *SqlLogger) Error(ctx context.Context, msg string, data ...interface{}) {
+	logger.Error("%s gorm: %s", getSqlLogContext(ctx).reqId, fmt.Sprintf(msg, data...))
+}
+
+/**
+ * 记录一条执行完成的语句，记录不存在的错误不作为错误记录
+ */
+func (this *SqlLogger) Trace(ctx context.Context, begin time.Time, fc func() (sql string, rowsAffected int64), err error) {
+	logCtx := getSqlLogContext(ctx)
+	elapsed := time.Since(begin)
+	switch {
+	case err != nil && !errors.Is(err, gorm.ErrRecordNotFound):
+		sql, rows := fc()
+		logger.Error("%s gorm: %s [%.3fms] [rows:%s] %s error: %s", logCtx.reqId, utils.FileWithLineNum(), elapsedMs(elapsed), formatRows(rows), sql, err.Error())
+	case logCtx.mode == SQL_LOG_OFF:
+	case this.SlowThreshold > 0 && elapsed > this.SlowThreshold:
+		sql, rows := fc()
+		logger.Warn("%s gorm: %s slow sql >= %s [%.3fms] [rows:%s] %s", logCtx.reqId, utils.FileWithLineNum(), this.SlowThreshold, elapsedMs(elapsed), formatRows(rows), sql)
+	case this.LogStatements || logCtx.mode == SQL_LOG_ON:
+		sql, rows := fc()
+		logger.Debug("%s gorm: %s [%.3fms] [rows:%s] %s", logCtx.reqId, utils.FileWithLineNum(), elapsedMs(elapsed), formatRows(rows), sql)
+	}
+}
+
+/**
+ * 将耗时转换为毫秒
+ */
+func elapsedMs(elapsed time.Duration) float64 {
+	return float64(elapsed.Nanoseconds()) / 1e6
+}
+
+/**
+ * 格式化影响行数，-1表示gorm未统计
+ */
+func formatRows(rows int64) string {
+	if rows == -1 {
+		return "-"
+	}
+	return fmt.Sprintf("%d", rows)
+}
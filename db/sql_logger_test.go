@@ -0,0 +1,46 @@
+package db
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	gormlogger "gorm.io/gorm/logger"
+)
+
+func TestSqlLoggerConfig(t *testing.T) {
+	sqlLogger := (&ConnOptions{}).sqlLogger()
+	if sqlLogger.SlowThreshold != DEFAULT_SLOW_SQL_THRESHOLD || sqlLogger.LogStatements {
+		t.Fatalf("unexpected default sql logger %+v", sqlLogger)
+	}
+	sqlLogger = (&ConnOptions{SlowThreshold: -1, LogStatements: true}).sqlLogger()
+	if sqlLogger.SlowThreshold > 0 || !sqlLogger.LogStatements {
+		t.Fatalf("negative threshold should disable slow sql log %+v", sqlLogger)
+	}
+	debugLogger := sqlLogger.LogMode(gormlogger.Info).(*SqlLogger)
+	silentLogger := sqlLogger.LogMode(gormlogger.Silent).(*SqlLogger)
+	if !debugLogger.LogStatements || silentLogger.LogStatements || silentLogger.SlowThreshold != 0 || debugLogger == sqlLogger {
+		t.Fatal("log mode should return a configured copy")
+	}
+	//未携带请求信息的context按默认方式记录
+	sqlLogger.Trace(context.Background(), time.Now(), func() (string, int64) { return "SELECT 1", -1 }, nil)
+}
+
+func TestSqlLogContext(t *testing.T) {
+	ctx := WithSqlLog(context.Background(), "req-1", SQL_LOG_OFF)
+	logCtx := getSqlLogContext(ctx)
+	if logCtx.reqId != "req-1" || logCtx.mode != SQL_LOG_OFF {
+		t.Fatalf("unexpected sql log context %+v", logCtx)
+	}
+	if logCtx = getSqlLogContext(context.Background()); logCtx.reqId != "" || logCtx.mode != SQL_LOG_DEFAULT {
+		t.Fatal("empty context should use default sql log")
+	}
+	traced := false
+	(&SqlLogger{LogStatements: true}).Trace(ctx, time.Now(), func() (string, int64) {
+		traced = true
+		return "", 0
+	}, nil)
+	if traced {
+		t.Fatal("statement should not be logged when sql log is off")
+	}
+}
@@ -0,0 +1,318 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+
+	"gorm.io/gorm/schema"
+)
+
+/**
+ * 结构体字段与列名的对应关系，按结构体类型缓存
+ */
+var columnFieldsCache sync.Map
+
+/**
+ * 列名的推导规则：优先使用db标签，其次使用gorm标签中的column，都没有时按gorm的默认命名策略转换为蛇形命名；
+ * db或gorm标签为"-"的字段被忽略，匿名嵌入的结构体字段展开到外层
+ */
+var defaultColumnNaming = schema.NamingStrategy{}
+
+/**
+ * 获取结构体类型的列名到字段索引路径的映射，外层字段优先于嵌入结构体中的同名字段
+ */
+func columnFields(structType reflect.Type) map[string][]int {
+	if fields, ok := columnFieldsCache.Load(structType); ok {
+		return fields.(map[string][]int)
+	}
+	fields := make(map[string][]int)
+	collectColumnFields(structType, nil, fields)
+	columnFieldsCache.Store(structType, fields)
+	return fields
+}
+
+/**
+ * 递归收集结构体的列字段，先收集当前层的字段，再展开嵌入的结构体
+ */
+func collectColumnFields(structType reflect.Type, index []int, fields map[string][]int) {
+	var embedded []reflect.StructField
+	for i := 0; i < structType.NumField(); i++ {
+		field := structType.Field(i)
+		column, ok := scanColumnName(field)
+		if !ok {
+			continue
+		}
+		if column == "" {
+			embedded = append(embedded, field)
+			continue
+		}
+		if _, exists := fields[column]; !exists {
+			fields[column] = append(append([]int(nil), index...), i)
+		}
+	}
+	for _, field := range embedded {
+		fieldType := field.Type
+		if fieldType.Kind() == reflect.Ptr {
+			fieldType = fieldType.Elem()
+		}
+		collectColumnFields(fieldType, append(append([]int(nil), index...), field.Index[0]), fields)
+	}
+}
+
+/**
+ * 获取字段对应的列名，字段被忽略时返回false，需要展开的嵌入结构体返回空列名
+ */
+func scanColumnName(field reflect.StructField) (string, bool) {
+	//未导出的字段中只有嵌入的结构体（非指针）可以展开
+	if field.PkgPath != "" && !(field.Anonymous && field.Type.Kind() == reflect.Struct) {
+		return "", false
+	}
+	dbTag := strings.Split(field.Tag.Get("db"), ",")[0]
+	if dbTag == "-" {
+		return "", false
+	}
+	if dbTag != "" {
+		return dbTag, true
+	}
+	gormSettings := schema.ParseTagSetting(field.Tag.Get("gorm"), ";")
+	if _, ignored := gormSettings["-"]; ignored {
+		return "", false
+	}
+	if column := gormSettings["COLUMN"]; column != "" {
+		return column, true
+	}
+	fieldType := field.Type
+	if fieldType.Kind() == reflect.Ptr {
+		fieldType = fieldType.Elem()
+	}
+	if field.Anonymous && fieldType.Kind() == reflect.Struct {
+		return "", true
+	}
+	return defaultColumnNaming.ColumnName("", field.Name), true
+}
+
+/**
+ * 获取结构体中索引路径对应的字段，沿途为nil的嵌入结构体指针会被初始化
+ */
+func fieldByIndexAlloc(structVal reflect.Value, index []int) reflect.Value {
+	value := structVal
+	for i, fieldIndex := range index {
+		if i > 0 && value.Kind() == reflect.Ptr {
+			if value.IsNil() {
+				value.Set(reflect.New(value.Type().Elem()))
+			}
+			value = value.Elem()
+		}
+		value = value.Field(fieldIndex)
+	}
+	return value
+}
+
+/**
+ * 判断类型是否作为单列的值扫描，而不是按列名映射到结构体字段，例如time.Time、sql.NullString等
+ */
+func isScalarType(valueType reflect.Type) bool {
+	if valueType.Kind() != reflect.Struct {
+		return true
+	}
+	return reflect.PtrTo(valueType).Implements(reflect.TypeOf((*sql.Scanner)(nil)).Elem()) || valueType.PkgPath() == "time"
+}
+
+/**
+ * 将结果集的当前行扫描到结构体，结构体中没有对应字段的列被丢弃
+ */
+func scanStruct(rows *sql.Rows, columns []string, structVal reflect.Value) error {
+	fields := columnFields(structVal.Type())
+	targets := make([]interface{}, len(columns))
+	for i, column := range columns {
+		index, ok := fields[column]
+		if !ok {
+			targets[i] = new(interface{})
+			continue
+		}
+		targets[i] = fieldByIndexAlloc(structVal, index).Addr().Interface()
+	}
+	return rows.Scan(targets...)
+}
+
+/**
+ * 将结果集的当前行扫描到value（结构体或单列的值）
+ */
+func scanRow(rows *sql.Rows, columns []string, value reflect.Value) error {
+	if isScalarType(value.Type()) {
+		if len(columns) != 1 {
+			return fmt.Errorf("sql: scan %d columns into scalar %s", len(columns), value.Type().String())
+		}
+		return rows.Scan(value.Addr().Interface())
+	}
+	return scanStruct(rows, columns, value)
+}
+
+/**
+ * 将结果集的第一行扫描到dest（结构体指针或基本类型指针）并关闭结果集，没有记录时返回sql.ErrNoRows
+ */
+func ScanOne(rows *sql.Rows, dest interface{}) error {
+	defer rows.Close()
+	destVal := reflect.ValueOf(dest)
+	if destVal.Kind() != reflect.Ptr || destVal.IsNil() {
+		return fmt.Errorf("sql: scan dest [%T] must be a non-nil ptr", dest)
+	}
+	columns, err := rows.Columns()
+	if err != nil {
+		return err
+	}
+	if !rows.Next() {
+		if err = rows.Err(); err != nil {
+			return err
+		}
+		return sql.ErrNoRows
+	}
+	err = scanRow(rows, columns, destVal.Elem())
+	if err != nil {
+		return err
+	}
+	return rows.Close()
+}
+
+/**
+ * 将结果集的所有行追加到dest（结构体、结构体指针或基本类型切片的指针）并关闭结果集
+ */
+func ScanAll(rows *sql.Rows, dest interface{}) error {
+	defer rows.Close()
+	destVal := reflect.ValueOf(dest)
+	if destVal.Kind() != reflect.Ptr || destVal.IsNil() || destVal.Elem().Kind() != reflect.Slice {
+		return fmt.Errorf("sql: scan dest [%T] must be a slice ptr", dest)
+	}
+	columns, err := rows.Columns()
+	if err != nil {
+		return err
+	}
+	sliceVal := destVal.Elem()
+	elemType := sliceVal.Type().Elem()
+	isPtr := elemType.Kind() == reflect.Ptr
+	if isPtr {
+		elemType = elemType.Elem()
+	}
+	for rows.Next() {
+		elemVal := reflect.New(elemType)
+		err = scanRow(rows, columns, elemVal.Elem())
+		if err != nil {
+			return err
+		}
+		if isPtr {
+			sliceVal.Set(reflect.Append(sliceVal, elemVal))
+		} else {
+			sliceVal.Set(reflect.Append(sliceVal, elemVal.Elem()))
+		}
+	}
+	return rows.Err()
+}
+
+/**
+ * 将:name形式的命名参数替换为方言对应的占位符（PostgreSQL为$1、$2...，其它方言为?），并按出现顺序从arg中取出参数值。
+ * 引号中的内容及::（PostgreSQL的类型转换）不作为命名参数处理
+ */
+func bindNamed(dialect, query string, arg interface{}) (string, []interface{}, error) {
+	boundQuery, names := parseNamedQuery(dialect, query)
+	if len(names) == 0 {
+		return boundQuery, nil, nil
+	}
+	lookUp, err := namedArgLookUp(arg)
+	if err != nil {
+		return "", nil, err
+	}
+	args := make([]interface{}, len(names))
+	for i, name := range names {
+		value, ok := lookUp(name)
+		if !ok {
+			return "", nil, fmt.Errorf("sql: named parameter :%s is not found in %T", name, arg)
+		}
+		args[i] = value
+	}
+	return boundQuery, args, nil
+}
+
+/**
+ * 解析命名参数，返回替换为占位符后的SQL及参数名列表
+ */
+func parseNamedQuery(dialect, query string) (string, []string) {
+	var builder strings.Builder
+	var names []string
+	var quote byte
+	for i := 0; i < len(query); i++ {
+		c := query[i]
+		switch {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '\'' || c == '"' || c == '`':
+			quote = c
+		case c == ':' && i+1 < len(query) && query[i+1] == ':':
+			builder.WriteString("::")
+			i++
+			continue
+		case c == ':' && i+1 < len(query) && isNameChar(query[i+1]):
+			end := i + 1
+			for end < len(query) && isNameChar(query[end]) {
+				end++
+			}
+			names = append(names, query[i+1:end])
+			if dialect == DIALECT_POSTGRES {
+				builder.WriteString("$" + strconv.Itoa(len(names)))
+			} else {
+				builder.WriteByte('?')
+			}
+			i = end - 1
+			continue
+		}
+		builder.WriteByte(c)
+	}
+	return builder.String(), names
+}
+
+/**
+ * 判断是否为命名参数中允许的字符
+ */
+func isNameChar(c byte) bool {
+	return c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9'
+}
+
+/**
+ * 获取按参数名取值的函数，arg为map[string]interface{}时按键取值，为结构体时按列名取字段值
+ */
+func namedArgLookUp(arg interface{}) (func(name string) (interface{}, bool), error) {
+	if values, ok := arg.(map[string]interface{}); ok {
+		return func(name string) (interface{}, bool) {
+			value, ok := values[name]
+			return value, ok
+		}, nil
+	}
+	argVal := reflect.ValueOf(arg)
+	for argVal.Kind() == reflect.Ptr {
+		if argVal.IsNil() {
+			return nil, errors.New("sql: named arg is nil")
+		}
+		argVal = argVal.Elem()
+	}
+	if argVal.Kind() != reflect.Struct {
+		return nil, fmt.Errorf("sql: named arg [%T] must be map[string]interface{} or struct", arg)
+	}
+	fields := columnFields(argVal.Type())
+	return func(name string) (interface{}, bool) {
+		index, ok := fields[name]
+		if !ok {
+			return nil, false
+		}
+		value, err := argVal.FieldByIndexErr(index)
+		if err != nil {
+			return nil, true
+		}
+		return value.Interface(), true
+	}, nil
+}
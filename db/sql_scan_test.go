@@ -0,0 +1,71 @@
+package db
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+type ScanTestBase struct {
+	Id        int64
+	CreatedAt time.Time
+}
+
+type scanTestOrder struct {
+	ScanTestBase
+	OrderNo string  `db:"order_no"`
+	Amount  float64 `gorm:"column:total_amount"`
+	Status  string
+	Memo    string `db:"-"`
+	Remark  string `gorm:"-"`
+	secret  string
+}
+
+func TestColumnFields(t *testing.T) {
+	fields := columnFields(reflect.TypeOf(scanTestOrder{}))
+	expected := map[string][]int{
+		"id":           {0, 0},
+		"created_at":   {0, 1},
+		"order_no":     {1},
+		"total_amount": {2},
+		"status":       {3},
+	}
+	if !reflect.DeepEqual(fields, expected) {
+		t.Fatalf("unexpected column fields: %v", fields)
+	}
+}
+
+func TestParseNamedQuery(t *testing.T) {
+	query, names := parseNamedQuery(DIALECT_MYSQL, "SELECT * FROM t WHERE a = :a AND b IN (:b_1, :a) AND c = ':x' AND d = e::text")
+	if query != "SELECT * FROM t WHERE a = ? AND b IN (?, ?) AND c = ':x' AND d = e::text" {
+		t.Fatalf("unexpected query: %s", query)
+	}
+	if !reflect.DeepEqual(names, []string{"a", "b_1", "a"}) {
+		t.Fatalf("unexpected names: %v", names)
+	}
+	query, _ = parseNamedQuery(DIALECT_POSTGRES, "SELECT * FROM t WHERE a = :a AND b IN (:b_1, :a) AND d = e::text")
+	if query != "SELECT * FROM t WHERE a = $1 AND b IN ($2, $3) AND d = e::text" {
+		t.Fatalf("unexpected postgres query: %s", query)
+	}
+}
+
+func TestBindNamed(t *testing.T) {
+	query, args, err := bindNamed(DIALECT_MYSQL, "UPDATE t SET status = :status WHERE id = :id", map[string]interface{}{"id": 1, "status": "paid"})
+	if err != nil || query != "UPDATE t SET status = ? WHERE id = ?" || !reflect.DeepEqual(args, []interface{}{"paid", 1}) {
+		t.Fatalf("unexpected bind by map: %s %v %v", query, args, err)
+	}
+	order := &scanTestOrder{OrderNo: "A1", Amount: 9.5}
+	order.Id = 7
+	_, args, err = bindNamed(DIALECT_MYSQL, "INSERT INTO t VALUES (:id, :order_no, :total_amount)", order)
+	if err != nil || !reflect.DeepEqual(args, []interface{}{int64(7), "A1", 9.5}) {
+		t.Fatalf("unexpected bind by struct: %v %v", args, err)
+	}
+	_, _, err = bindNamed(DIALECT_MYSQL, "SELECT :memo", order)
+	if err == nil {
+		t.Fatal("ignored field should not be bound")
+	}
+	_, _, err = bindNamed(DIALECT_MYSQL, "SELECT :id", 1)
+	if err == nil {
+		t.Fatal("scalar arg should be rejected")
+	}
+}
@@ -0,0 +1,16 @@
+/**
+ * 基于纯Go实现（不依赖cgo）的SQLite方言，导入本包后即可通过GormProxy.OpenSqlite打开文件或内存数据库：
+ * import _ "github.com/duhaifeng/simpleapi/db/sqlite"
+ */
+package sqlite
+
+import (
+	"github.com/duhaifeng/simpleapi/db"
+	"github.com/glebarez/sqlite"
+)
+
+func init() {
+	db.RegisterDialect(db.DIALECT_SQLITE, sqlite.Open)
+	//兼容旧版本使用的sqlite3方言名称
+	db.RegisterDialect("sqlite3", sqlite.Open)
+}
@@ -0,0 +1,537 @@
+package sqlite
+
+import (
+	"io"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+	"testing/fstest"
+
+	"github.com/duhaifeng/simpleapi/db"
+	"github.com/google/uuid"
+)
+
+type GatewayUserList []*GatewayUserEntry
+
+type GatewayUserEntry struct {
+	Id           int `gorm:"primary_key:yes"`
+	UserId       string
+	UserName     string
+	UserPassword string
+}
+
+func (*GatewayUserEntry) TableName() string {
+	return "gw_user"
+}
+
+func newGatewayUser() *GatewayUserEntry {
+	gwUser := new(GatewayUserEntry)
+	gwUser.UserId = uuid.New().String()
+	gwUser.UserName = gwUser.UserId
+	gwUser.UserPassword = "xxxxxxxx"
+	return gwUser
+}
+
+/**
+ * 打开测试用的SQLite内存数据库并为models建表，测试结束时关闭
+ */
+func newTestDB(t *testing.T, models ...interface{}) *db.GormProxy {
+	proxy := new(db.GormProxy)
+	err := proxy.OpenSqlite(":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		proxy.Close()
+	})
+	if len(models) > 0 {
+		err = proxy.Conn.AutoMigrate(models...)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	return proxy
+}
+
+func countGatewayUsers(t *testing.T, proxy *db.GormProxy) int64 {
+	var count int64
+	err := proxy.Conn.Model(new(GatewayUserEntry)).Count(&count).Error
+	if err != nil {
+		t.Fatal("[db error] count gateway user failed: ", err.Error())
+	}
+	return count
+}
+
+func TestSqliteTransaction(t *testing.T) {
+	proxy := newTestDB(t, new(GatewayUserEntry))
+
+	tx, err := proxy.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = tx.Conn.Create(newGatewayUser()).Error
+	if err != nil {
+		t.Fatal("[db error] add gateway user failed: ", err.Error())
+	}
+	var userList GatewayUserList
+	err = tx.Conn.Find(&userList).Error
+	if err != nil || len(userList) != 1 {
+		t.Fatalf("[db error] get user list in transaction: %v %d", err, len(userList))
+	}
+	err = tx.Rollback()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if countGatewayUsers(t, proxy) != 0 {
+		t.Fatal("rolled back user should not be saved")
+	}
+}
+
+func TestSqliteNestedTransaction(t *testing.T) {
+	proxy := newTestDB(t, new(GatewayUserEntry))
+
+	tx, _ := proxy.Begin()
+	tx.Conn.Create(newGatewayUser())
+	nestedTx, err := tx.Begin()
+	if err != nil || nestedTx.GetTxDepth() != 2 {
+		t.Fatalf("nested begin should create a savepoint: %v", err)
+	}
+	nestedTx.Conn.Create(newGatewayUser())
+	err = nestedTx.Rollback()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = tx.Commit()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if countGatewayUsers(t, proxy) != 1 {
+		t.Fatal("only the user outside the rolled back savepoint should be saved")
+	}
+}
+
+func TestSqliteSiblingSavepoints(t *testing.T) {
+	proxy := newTestDB(t, new(GatewayUserEntry))
+
+	//同一事务中未结束的兄弟嵌套事务使用不同的保存点，回滚前一个不能只回滚到后一个的保存点
+	tx, _ := proxy.Begin()
+	firstTx, _ := tx.Begin()
+	firstTx.Conn.Create(newGatewayUser())
+	secondTx, err := tx.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	secondTx.Conn.Create(newGatewayUser())
+	err = firstTx.Rollback()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = tx.Commit()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if countGatewayUsers(t, proxy) != 0 {
+		t.Fatal("rolling back the first savepoint should discard the users after it")
+	}
+}
+
+type AuditFields struct {
+	CreateTime int64
+	Creator    string `gorm:"column:created_by"`
+}
+
+type ProductEntry struct {
+	Id    int64
+	Code  string `gorm:"uniqueIndex"`
+	Price int
+	Note  string `gorm:"-"`
+	AuditFields
+}
+
+func TestSqliteBatchInsert(t *testing.T) {
+	proxy := newTestDB(t, new(ProductEntry))
+
+	products := make([]*ProductEntry, 5)
+	for i := range products {
+		products[i] = &ProductEntry{Code: uuid.New().String(), Price: i, Note: "ignored", AuditFields: AuditFields{CreateTime: 100, Creator: "tester"}}
+	}
+	err := proxy.BatchInsertWith(products, &db.BatchOptions{BatchSize: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, product := range products {
+		if product.Id != int64(i+1) {
+			t.Fatalf("auto increment id should be filled back: %d %d", i, product.Id)
+		}
+	}
+	var saved ProductEntry
+	err = proxy.Conn.First(&saved, products[4].Id).Error
+	if err != nil || saved.Creator != "tester" || saved.CreateTime != 100 || saved.Note != "" {
+		t.Fatalf("saved product is not expected: %v %+v", err, saved)
+	}
+
+	upserts := []ProductEntry{{Code: products[0].Code, Price: 10, AuditFields: AuditFields{Creator: "updater"}}, {Code: uuid.New().String(), Price: 20}}
+	err = proxy.BatchUpsert(upserts, []string{"Code"}, "Price")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var updated ProductEntry
+	err = proxy.Conn.First(&updated, products[0].Id).Error
+	if err != nil || updated.Price != 10 || updated.Creator != "tester" {
+		t.Fatalf("only price should be updated on conflict: %v %+v", err, updated)
+	}
+	var count int64
+	proxy.Conn.Model(new(ProductEntry)).Count(&count)
+	if count != 6 {
+		t.Fatalf("upsert should insert the new product: %d", count)
+	}
+	if err = proxy.BatchInsert([]ProductEntry{{Code: products[1].Code}}); !db.IsDuplicateError(err) {
+		t.Fatalf("duplicate insert should fail with duplicate error: %v", err)
+	}
+}
+
+/**
+ * 批量写入count个编码随机的商品，第i个商品的价格为price(i)
+ */
+func seedProducts(t *testing.T, proxy *db.GormProxy, count int, price func(i int) int) []*ProductEntry {
+	products := make([]*ProductEntry, count)
+	for i := range products {
+		products[i] = &ProductEntry{Code: uuid.New().String(), Price: price(i)}
+	}
+	err := proxy.BatchInsert(products)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return products
+}
+
+func TestSqliteBatchUpdateAndDelete(t *testing.T) {
+	proxy := newTestDB(t, new(ProductEntry))
+	products := seedProducts(t, proxy, 7, func(i int) int { return i })
+
+	for i := range products {
+		products[i].Price = 100 + i
+		products[i].Creator = "updater"
+	}
+	result, err := proxy.BatchUpdateWith(products[:5], "Code", &db.BatchOptions{BatchSize: 2, UpdateColumns: []string{"Price"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.RowsAffected != 5 || len(result.BatchRows) != 3 || result.BatchRows[2] != 1 {
+		t.Fatalf("unexpected batch update result: %+v", result)
+	}
+	var saved []ProductEntry
+	proxy.Conn.Order("id").Find(&saved)
+	for i, product := range saved {
+		if (i < 5 && product.Price != 100+i) || (i >= 5 && product.Price != i) || product.Creator != "" {
+			t.Fatalf("product %d is not updated as expected: %+v", i, product)
+		}
+	}
+
+	result, err = proxy.BatchDeleteWith(new(ProductEntry), "", []int64{products[0].Id, products[2].Id, products[4].Id, 1000}, &db.BatchOptions{BatchSize: 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.RowsAffected != 3 || len(result.BatchRows) != 2 || result.BatchRows[1] != 0 {
+		t.Fatalf("unexpected batch delete result: %+v", result)
+	}
+	var count int64
+	proxy.Conn.Model(new(ProductEntry)).Count(&count)
+	if count != 4 {
+		t.Fatalf("deleted products should be removed: %d", count)
+	}
+	if _, err = proxy.BatchDelete(ProductEntry{}, "", []int64{1}); err == nil {
+		t.Fatal("batch delete model should be a struct ptr")
+	}
+}
+
+func TestSqliteBulkLoad(t *testing.T) {
+	proxy := newTestDB(t, new(ProductEntry))
+
+	ch := make(chan *ProductEntry)
+	go func() {
+		for i := 0; i < 7; i++ {
+			ch <- &ProductEntry{Code: uuid.New().String(), Price: i}
+		}
+		close(ch)
+	}()
+	var progresses []db.BulkLoadProgress
+	options := &db.BulkLoadOptions{BatchOptions: db.BatchOptions{BatchSize: 3}, TxPerBatch: true, OnProgress: func(progress db.BulkLoadProgress) {
+		progresses = append(progresses, progress)
+	}}
+	progress, err := proxy.BulkLoad(db.ChanSource(ch), options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if progress.Rows != 7 || progress.Batches != 3 || len(progresses) != 3 || progresses[1].Rows != 6 {
+		t.Fatalf("unexpected bulk load progress: %+v %+v", progress, progresses)
+	}
+
+	//第二批中的重复记录使该批回滚，之前提交的批次保留
+	duplicated := uuid.New().String()
+	rows := []ProductEntry{{Code: uuid.New().String()}, {Code: uuid.New().String()}, {Code: duplicated}, {Code: duplicated}, {Code: uuid.New().String()}}
+	next := 0
+	iterator := func() (interface{}, error) {
+		if next >= len(rows) {
+			return nil, io.EOF
+		}
+		next++
+		return rows[next-1], nil
+	}
+	progress, err = proxy.BulkLoad(iterator, &db.BulkLoadOptions{BatchOptions: db.BatchOptions{BatchSize: 2}, TxPerBatch: true})
+	if err == nil || progress.Rows != 2 || progress.Batches != 1 {
+		t.Fatalf("duplicated batch should fail: %v %+v", err, progress)
+	}
+	if countProducts(t, proxy) != 9 {
+		t.Fatal("committed batches should be kept")
+	}
+	if _, err = proxy.BulkLoad(iterator, &db.BulkLoadOptions{LoadData: true}); err == nil {
+		t.Fatal("load data should not be supported by sqlite")
+	}
+}
+
+func countProducts(t *testing.T, proxy *db.GormProxy) int64 {
+	var count int64
+	err := proxy.Conn.Model(new(ProductEntry)).Count(&count).Error
+	if err != nil {
+		t.Fatal("[db error] count product failed: ", err.Error())
+	}
+	return count
+}
+
+func TestSqlitePagination(t *testing.T) {
+	proxy := newTestDB(t, new(ProductEntry))
+	seedProducts(t, proxy, 11, func(i int) int { return i % 4 })
+
+	sortFields := map[string]string{"price": "price", "id": "id"}
+	query, _ := url.ParseQuery("page=2&page_size=3&sort=-price,id")
+	pagination, err := db.ParsePagination(query, sortFields, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var page []*ProductEntry
+	result, err := pagination.Paginate(proxy.Conn.Where("price > ?", 0), &page)
+	if err != nil {
+		t.Fatal(err)
+	}
+	//price > 0 的记录按价格降序、id升序：3(4,8) 2(3,7,11) 1(2,6,10)，第二页为 7,11,2
+	if result.Total != 8 || !result.HasMore || len(page) != 3 || page[0].Id != 7 || page[2].Id != 2 {
+		t.Fatalf("unexpected offset page: %+v %+v", result, page)
+	}
+
+	pagination.Sorts = []db.SortField{{Column: "price", Desc: true}}
+	var ids []int64
+	for {
+		var rows []ProductEntry
+		result, err = pagination.PaginateByCursor(proxy.Conn, &rows)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, row := range rows {
+			ids = append(ids, row.Id)
+		}
+		if !result.HasMore {
+			break
+		}
+		pagination.Cursor = result.NextCursor
+	}
+	//价格相同时按追加的主键降序
+	expectedIds := []int64{8, 4, 11, 7, 3, 10, 6, 2, 9, 5, 1}
+	if !reflect.DeepEqual(ids, expectedIds) {
+		t.Fatalf("cursor pages should cover all rows in order: %v", ids)
+	}
+}
+
+func TestSqliteFilters(t *testing.T) {
+	proxy := newTestDB(t, new(ProductEntry))
+	products := []*ProductEntry{{Code: "a_1", Price: 1}, {Code: "ab1", Price: 2}, {Code: "b_2", Price: 3}, {Code: "c", Price: 4}}
+	if err := proxy.BatchInsert(products); err != nil {
+		t.Fatal(err)
+	}
+	fields := map[string]string{"code": "code", "price": "price"}
+	cases := map[string][]int64{
+		"code=like:_":              {1, 3},
+		"price=in:1,3,4&code=ne:c": {1, 3},
+		"price=between:2,3":        {2, 3},
+		"price=gt:1&price=lte:3":   {2, 3},
+		"code=null:false&price=4":  {4},
+	}
+	for rawQuery, expectedIds := range cases {
+		query, _ := url.ParseQuery(rawQuery)
+		filters, err := db.ParseFilters(query, fields)
+		if err != nil {
+			t.Fatal(err)
+		}
+		filtered, err := db.ApplyFilters(proxy.Conn, new(ProductEntry), filters)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var ids []int64
+		err = filtered.Order("id").Pluck("id", &ids).Error
+		if err != nil || !reflect.DeepEqual(ids, expectedIds) {
+			t.Errorf("unexpected result of %s: %v %v", rawQuery, err, ids)
+		}
+	}
+}
+
+func TestSqliteMigration(t *testing.T) {
+	proxy := newTestDB(t)
+	migrator := db.NewMigrator(proxy)
+	err := migrator.LoadSqlFiles(fstest.MapFS{
+		"sql/1_create_account.up.sql":   {Data: []byte("CREATE TABLE account (id INTEGER PRIMARY KEY, name TEXT);\nINSERT INTO account (name) VALUES ('admin');")},
+		"sql/1_create_account.down.sql": {Data: []byte("DROP TABLE account;")},
+	}, "sql")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = migrator.Register(&db.Migration{Version: 2, Name: "add_email", Up: func(tx *db.GormProxy) error {
+		return tx.Conn.Exec("ALTER TABLE account ADD COLUMN email TEXT").Error
+	}, Down: func(tx *db.GormProxy) error {
+		return tx.Conn.Exec("ALTER TABLE account DROP COLUMN email").Error
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	migrator.Register(&db.Migration{Version: 3, Name: "broken", Up: func(tx *db.GormProxy) error {
+		return tx.Conn.Exec("INSERT INTO missing_table VALUES (1)").Error
+	}})
+
+	pending, err := migrator.Pending()
+	if err != nil || len(pending) != 3 {
+		t.Fatalf("all migrations should be pending before the first upgrade: %v %+v", err, pending)
+	}
+	if proxy.Conn.Migrator().HasTable(db.DEFAULT_MIGRATION_TABLE) {
+		t.Fatal("checking pending migrations should not create the history table")
+	}
+
+	done, err := migrator.Up()
+	if err == nil || len(done) != 2 {
+		t.Fatalf("broken migration should stop the upgrade: %v %d", err, len(done))
+	}
+	pending, _ = migrator.Pending()
+	if len(pending) != 1 || pending[0].Version != 3 {
+		t.Fatalf("only the broken migration should be pending: %+v", pending)
+	}
+	var count int64
+	proxy.Conn.Table("account").Where("email IS NULL").Count(&count)
+	if count != 1 {
+		t.Fatal("applied migrations should change the schema")
+	}
+
+	output := new(strings.Builder)
+	err = migrator.RunCommand([]string{"down", "2"}, output)
+	if err != nil || output.String() != "rolled back 2_add_email\nrolled back 1_create_account\n" {
+		t.Fatalf("unexpected rollback: %v %s", err, output.String())
+	}
+	if proxy.Conn.Migrator().HasTable("account") {
+		t.Fatal("rolled back table should be dropped")
+	}
+	statuses, err := migrator.Status()
+	if err != nil || len(statuses) != 3 || statuses[0].Applied || statuses[2].Applied {
+		t.Fatalf("all migrations should be pending after rollback: %v %+v", err, statuses)
+	}
+}
+
+type ProductRow struct {
+	AuditFields
+	Id    int64
+	Name  string `db:"code"`
+	Price int
+}
+
+func TestSqliteSqlProxy(t *testing.T) {
+	proxy := newTestDB(t, new(ProductEntry))
+	sqlProxy, err := proxy.SqlProxy()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"apple", "banana", "cherry"} {
+		_, err = sqlProxy.NamedExec("INSERT INTO product_entries (code, price, create_time, created_by) VALUES (:code, :price, :now, :creator)", map[string]interface{}{"code": name, "price": len(name), "now": 1, "creator": "tester"})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	var rows []*ProductRow
+	err = sqlProxy.Select(&rows, "SELECT * FROM product_entries WHERE price > ? ORDER BY id", 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 2 || rows[0].Name != "banana" || rows[1].Price != 6 || rows[0].Id == 0 || rows[0].Creator != "tester" {
+		t.Fatalf("unexpected selected rows: %+v", rows)
+	}
+	var name string
+	err = sqlProxy.Get(&name, "SELECT code FROM product_entries WHERE price = ?", 5)
+	if err != nil || name != "apple" {
+		t.Fatalf("unexpected scalar: %s %v", name, err)
+	}
+	err = sqlProxy.Get(new(ProductRow), "SELECT * FROM product_entries WHERE code = ?", "missing")
+	if !sqlProxy.IsNoRowError(err) {
+		t.Fatalf("missing row should return no rows error: %v", err)
+	}
+
+	//原生SQL的事务在Begin返回的连接上执行，事务外的连接仍然可以查询
+	tx, err := sqlProxy.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = tx.NamedExec("DELETE FROM product_entries WHERE code = :code", &ProductRow{Name: "apple"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = tx.Rollback()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if countProducts(t, proxy) != 3 {
+		t.Fatal("rolled back delete should not take effect")
+	}
+
+	//GormProxy事务中获取的原生SQL连接加入同一个事务
+	ormTx, err := proxy.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	txSqlProxy, err := ormTx.SqlProxy()
+	if err != nil || !txSqlProxy.IsInTx() {
+		t.Fatalf("sql proxy should join the orm transaction: %v", err)
+	}
+	_, err = txSqlProxy.Exec("DELETE FROM product_entries WHERE code = ?", "cherry")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = ormTx.Rollback()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if countProducts(t, proxy) != 3 {
+		t.Fatal("delete in rolled back orm transaction should not take effect")
+	}
+
+	//借用GormProxy连接池的原生SQL连接关闭时不能关闭共用的连接池
+	err = sqlProxy.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if countProducts(t, proxy) != 3 {
+		t.Fatal("orm conn should still work after closing the borrowed sql proxy")
+	}
+}
+
+func TestSqliteErrorClassify(t *testing.T) {
+	proxy := newTestDB(t, new(GatewayUserEntry))
+
+	user := newGatewayUser()
+	err := proxy.Conn.Create(user).Error
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = proxy.Conn.Create(&GatewayUserEntry{Id: user.Id, UserId: "dup"}).Error
+	if !db.IsDuplicateError(err) {
+		t.Fatalf("duplicate primary key should be classified by sqlite error code: %v", err)
+	}
+	err = proxy.Conn.First(new(GatewayUserEntry), "user_id = ?", "absent").Error
+	if !db.IsNotFoundError(err) {
+		t.Fatalf("missing record should be classified as not found: %v", err)
+	}
+}
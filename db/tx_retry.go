@@ -0,0 +1,109 @@
+package db
+
+import (
+	"errors"
+	"math/rand"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+const (
+	MYSQL_ERR_LOCK_WAIT_TIMEOUT = 1205
+	MYSQL_ERR_DEADLOCK          = 1213
+)
+
+/**
+ * 事务重试策略：第N次重试前等待 InitialBackoff * 2^(N-1)（不超过MaxBackoff）并叠加随机抖动，避免冲突的事务同时重试
+ */
+type TxRetryPolicy struct {
+	MaxAttempts    int //最多执行的次数（包括第一次执行）
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+}
+
+/**
+ * 默认的事务重试策略
+ */
+var DefaultTxRetryPolicy = TxRetryPolicy{MaxAttempts: 3, InitialBackoff: 20 * time.Millisecond, MaxBackoff: 500 * time.Millisecond}
+
+/**
+ * 获取第attempt次执行失败后需要等待的时长
+ */
+func (this TxRetryPolicy) Backoff(attempt int) time.Duration {
+	backoff := this.InitialBackoff
+	for i := 1; i < attempt && backoff < this.MaxBackoff; i++ {
+		backoff *= 2
+	}
+	if this.MaxBackoff > 0 && backoff > this.MaxBackoff {
+		backoff = this.MaxBackoff
+	}
+	if backoff <= 0 {
+		return 0
+	}
+	return backoff/2 + time.Duration(rand.Int63n(int64(backoff/2)+1))
+}
+
+/**
+ * 判断是否为重新执行整个事务即可能成功的错误：MySQL死锁(1213)、锁等待超时(1205)及PostgreSQL的死锁、序列化失败
+ */
+func IsRetryableTxError(err error) bool {
+	if err == nil {
+		return false
+	}
+	var mysqlErr *mysql.MySQLError
+	if errors.As(err, &mysqlErr) {
+		return mysqlErr.Number == MYSQL_ERR_DEADLOCK || mysqlErr.Number == MYSQL_ERR_LOCK_WAIT_TIMEOUT
+	}
+	var stateErr sqlStateError
+	if errors.As(err, &stateErr) {
+		return stateErr.SQLState() == "40001" || stateErr.SQLState() == "40P01"
+	}
+	return false
+}
+
+/**
+ * 在事务中执行fn：fn返回nil时提交，返回错误或panic时回滚。遇到可重试的错误时回滚并按策略等待后重新执行整个事务，
+ * 每次重试都会以reqId记录日志。死锁时数据库已经回滚了整个事务，因此在已有事务中调用时只通过保存点执行一次，由最外层事务负责重试
+ */
+func (this *GormProxy) InRetryTransaction(reqId string, policy TxRetryPolicy, fn func(tx *GormProxy) error) error {
+	if this.IsInTx() {
+		return this.runInTx(reqId, fn)
+	}
+	var err error
+	for attempt := 1; ; attempt++ {
+		err = this.runInTx(reqId, fn)
+		if err == nil || !IsRetryableTxError(err) || attempt >= policy.MaxAttempts {
+			return err
+		}
+		backoff := policy.Backoff(attempt)
+		logger.Warn("%s gorm: transaction attempt %d/%d failed, retry after %s: %s", reqId, attempt, policy.MaxAttempts, backoff, err.Error())
+		time.Sleep(backoff)
+	}
+}
+
+/**
+ * 开启事务（或保存点）执行一次fn
+ */
+func (this *GormProxy) runInTx(reqId string, fn func(tx *GormProxy) error) error {
+	tx, err := this.Begin()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		panicErr := recover()
+		if panicErr != nil {
+			tx.Rollback()
+			panic(panicErr)
+		}
+	}()
+	err = fn(tx)
+	if err != nil {
+		rollbackErr := tx.Rollback()
+		if rollbackErr != nil {
+			logger.Error("%s gorm: rollback transaction failed: %s", reqId, rollbackErr.Error())
+		}
+		return err
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,45 @@
+package db
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+func TestIsRetryableTxError(t *testing.T) {
+	cases := []struct {
+		err       error
+		retryable bool
+	}{
+		{nil, false},
+		{&mysql.MySQLError{Number: 1213, Message: "Deadlock found when trying to get lock"}, true},
+		{fmt.Errorf("save order: %w", &mysql.MySQLError{Number: 1205, Message: "Lock wait timeout exceeded"}), true},
+		{&mysql.MySQLError{Number: 1062, Message: "Duplicate entry"}, false},
+		{fmt.Errorf("gorm: transaction commit failed. error:%w", &mysql.MySQLError{Number: 1213}), true},
+		{&testSqlStateError{"40001"}, true},
+		{&testSqlStateError{"40P01"}, true},
+		{&testSqlStateError{"23505"}, false},
+		//只识别驱动的错误类型，不按错误信息识别
+		{errors.New("Error 1213: Deadlock found when trying to get lock"), false},
+		{errors.New("record not found"), false},
+	}
+	for _, c := range cases {
+		if IsRetryableTxError(c.err) != c.retryable {
+			t.Errorf("IsRetryableTxError(%v) should be %v", c.err, c.retryable)
+		}
+	}
+}
+
+func TestTxRetryBackoff(t *testing.T) {
+	policy := TxRetryPolicy{MaxAttempts: 5, InitialBackoff: 10 * time.Millisecond, MaxBackoff: 40 * time.Millisecond}
+	expected := []time.Duration{10 * time.Millisecond, 20 * time.Millisecond, 40 * time.Millisecond, 40 * time.Millisecond}
+	for i, max := range expected {
+		backoff := policy.Backoff(i + 1)
+		if backoff < max/2 || backoff > max {
+			t.Errorf("backoff of attempt %d should be in [%s, %s], got %s", i+1, max/2, max, backoff)
+		}
+	}
+}
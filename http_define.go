@@ -3,6 +3,7 @@ package simpleapi
 import (
 	"encoding/json"
 	"fmt"
+	"github.com/duhaifeng/simpleapi/db"
 	"github.com/gorilla/mux"
 	"io/ioutil"
 	"mime/multipart"
@@ -43,6 +44,9 @@ type StructHandlerDef struct {
 	Method        string
 	Path          string
 	StructHandler interface{} //此处存放的必须是IHandleRequest接口，由于反射缘故，所以此处改用interface{}存放
+	Transactional bool        //是否在HandleRequest前后自动开启、提交或回滚事务
+	sqlLogMode    int32       //路由的SQL日志开关，见db.SQL_LOG_*定义。运行中可以通过SetRouteSqlLog修改，以原子操作读写
+	injectPlan    *handlerInjectPlan
 }
 
 /**
@@ -112,6 +116,21 @@ func (req *Request) GetUrlParam(key string) string {
 	return req.oriReq.URL.Query().Get(key)
 }
 
+/**
+ * 从请求URL参数中绑定分页及排序参数（page、page_size、sort、cursor），sortFields为允许排序的参数名到列名的白名单，
+ * 请求未指定排序时使用defaultSort
+ */
+func (req *Request) GetPagination(sortFields map[string]string, defaultSort string) (*db.Pagination, error) {
+	return db.ParsePagination(req.oriReq.URL.Query(), sortFields, defaultSort)
+}
+
+/**
+ * 从请求URL参数中绑定过滤条件（例如status=in:1,2），fields为允许过滤的参数名到列名的白名单
+ */
+func (req *Request) GetFilters(fields map[string]string) ([]*db.Filter, error) {
+	return db.ParseFilters(req.oriReq.URL.Query(), fields)
+}
+
 /**
  * 获取请求Form中包含的参数
  */
@@ -232,6 +251,13 @@ func (resp *Response) Write(body []byte) (int, error) {
  * 将响应消息转换为统一的Json格式写回客户端
  */
 func (resp *Response) JsonResponse(data interface{}) (int, error) {
+	return resp.JsonResponseWithCode(http.StatusOK, data)
+}
+
+/**
+ * 以指定的响应状态码将响应消息转换为Json格式写回客户端
+ */
+func (resp *Response) JsonResponseWithCode(httpCode int, data interface{}) (int, error) {
 	resp.SetHeader("Content-Type", "Application/json")
 	body, err := json.Marshal(data)
 	if err != nil {
@@ -239,7 +265,7 @@ func (resp *Response) JsonResponse(data interface{}) (int, error) {
 		resp.oriResp.Write([]byte(err.Error()))
 		return -1, err
 	}
-	resp.WriteHeader(http.StatusOK)
+	resp.WriteHeader(httpCode)
 	return resp.Write(body)
 }
 
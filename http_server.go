@@ -1,7 +1,9 @@
 package simpleapi
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
 	"os"
@@ -9,6 +11,8 @@ import (
 	"runtime"
 	"runtime/debug"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	log "github.com/duhaifeng/loglet"
@@ -29,7 +33,32 @@ type ApiServer struct {
 	funcHandlerDef    []*FuncHandlerDef
 	structHandlerDef  []*StructHandlerDef
 	interceptors      []IApiHandler
-	ormConn           *db.GormProxy //管理全局数据库链接
+	httpServer        *http.Server
+	ormConn           *db.GormProxy //管理全局数据库链接（默认数据源）
+	dataSources       map[string]*db.GormProxy
+	serviceScopes     map[reflect.Type]string
+	servicePlans      map[reflect.Type]*serviceInjectPlan
+	dbOperatorPlans   map[reflect.Type]*dbOperatorInjectPlan
+	components        []*componentDef
+	bindings          map[string]map[reflect.Type]reflect.Type //环境 -> 接口类型 -> Service实现类型
+	bindingEnv        string
+	configs           map[string]interface{}
+	singletonServices map[serviceKey]*singletonService
+	singletonLock     sync.Mutex
+	requiredMigrators []*db.Migrator //启动前必须已经执行完所有迁移的迁移管理器
+	dbErrorStatus     map[int]int    //数据库错误分类到响应状态码的映射，为nil时Handler返回的错误都以200响应
+}
+
+/**
+ * 通过EnableDbErrorStatus开启映射时数据库错误对应的响应状态码，其它错误仍以200响应
+ */
+var defaultDbErrorStatus = map[int]int{
+	db.DB_ERR_NOT_FOUND:   http.StatusNotFound,
+	db.DB_ERR_DUPLICATE:   http.StatusConflict,
+	db.DB_ERR_FOREIGN_KEY: http.StatusConflict,
+	db.DB_ERR_DEADLOCK:    http.StatusConflict,
+	db.DB_ERR_CONN_LOST:   http.StatusServiceUnavailable,
+	db.DB_ERR_TIMEOUT:     http.StatusGatewayTimeout,
 }
 
 /**
@@ -43,6 +72,7 @@ func (this *ApiServer) Init() {
 	this.tokenFunnel = new(TokenFunnel)
 	this.tokenFunnel.Init()
 	this.httpRouter = mux.NewRouter()
+	this.httpServer = new(http.Server)
 }
 
 func (this *ApiServer) SetNotFoundHandler(notFoundHandler http.Handler) {
@@ -54,6 +84,7 @@ func (this *ApiServer) SetNotFoundHandler(notFoundHandler http.Handler) {
  */
 func (this *ApiServer) SetLogger(outerLogger *log.Logger) {
 	logger = outerLogger
+	db.SetLogger(outerLogger)
 }
 
 /**
@@ -67,17 +98,22 @@ func (this *ApiServer) GetTokenFunnel() *TokenFunnel {
  * 打开服务器使用的全局数据库连接(MySQL)
  */
 func (this *ApiServer) OpenMySQLOrmConn(host, port, user, pass, database string) error {
-	this.ormConn = new(db.GormProxy)
-	return this.ormConn.OpenMySQL(host, port, user, pass, database)
+	return this.OpenMySQLDataSource(DEFAULT_DATA_SOURCE, host, port, user, pass, database)
 }
 
 /**
- * 打开服务器使用的全局数据库连接(Sqlite3)
+ * 打开服务器使用的全局数据库连接(PostgreSQL)，需要导入db/postgres包
  */
-//func (this *ApiServer) OpenSqliteOrmConn(filePath string) error {
-//	this.ormConn = new(db.GormProxy)
-//	return this.ormConn.OpenSqlite3(filePath)
-//}
+func (this *ApiServer) OpenPostgresOrmConn(host, port, user, pass, database string) error {
+	return this.OpenDataSourceWithOptions(DEFAULT_DATA_SOURCE, db.DIALECT_POSTGRES, host, port, user, pass, database, nil)
+}
+
+/**
+ * 打开服务器使用的全局数据库连接(SQLite)，filePath传入":memory:"时使用内存数据库，需要导入db/sqlite包
+ */
+func (this *ApiServer) OpenSqliteOrmConn(filePath string) error {
+	return this.OpenDataSourceWithOptions(DEFAULT_DATA_SOURCE, db.DIALECT_SQLITE, filePath, "", "", "", "", nil)
+}
 
 /**
  * 控制是否打印路由信息
@@ -91,17 +127,38 @@ func (this *ApiServer) PrintRouteTable(print bool) {
  */
 func (this *ApiServer) StartListen(addr, port string) {
 	this.Init()
+	//存在尚未执行的数据库迁移时拒绝启动
+	err := this.checkMigrations()
+	if err != nil {
+		logger.Error(err.Error())
+		time.Sleep(time.Second) //等待日志控制台输出
+		os.Exit(1)
+	}
 	this.registerFuncHandlerRoute()
 	this.registerStructHandlerRoute()
 	http.Handle("/", this.httpRouter)
 	listen := addr + ":" + port
 	logger.Info("start listen %s", listen)
-	err := http.ListenAndServe(listen, nil)
-	if err != nil {
+	this.httpServer.Addr = listen
+	err = this.httpServer.ListenAndServe()
+	if err != nil && err != http.ErrServerClosed {
 		logger.Error("can not listen %s for the reason: %s", listen, err.Error())
 	}
 }
 
+/**
+ * 优雅关闭服务器：停止接收新请求并等待处理中的请求结束（不超过ctx的时限），之后关闭所有数据源的连接
+ */
+func (this *ApiServer) Shutdown(ctx context.Context) error {
+	if this.httpServer != nil {
+		err := this.httpServer.Shutdown(ctx)
+		if err != nil {
+			logger.Error("shutdown http server failed: %s", err.Error())
+		}
+	}
+	return this.CloseDataSources()
+}
+
 /**
  * 设置是否允许跨域请求，如果允许，则需要为每个请求增加一个对应的options请求
  */
@@ -137,6 +194,59 @@ func (this *ApiServer) RegisterHandler(method, path string, handler interface{})
 	}
 }
 
+/**
+ * 开启或关闭一个已注册路由的SQL日志，开启时记录该路由执行的所有语句，关闭时不记录语句及慢查询。
+ * 服务运行中也可以调用，之后收到的请求按新的开关记录
+ */
+func (this *ApiServer) SetRouteSqlLog(method, path string, enabled bool) error {
+	for _, handlerDef := range this.structHandlerDef {
+		if handlerDef.Method != method || handlerDef.Path != path {
+			continue
+		}
+		if enabled {
+			atomic.StoreInt32(&handlerDef.sqlLogMode, db.SQL_LOG_ON)
+		} else {
+			atomic.StoreInt32(&handlerDef.sqlLogMode, db.SQL_LOG_OFF)
+		}
+		return nil
+	}
+	return fmt.Errorf("route <%s> %s is not registered", method, path)
+}
+
+/**
+ * 开启数据库错误到响应状态码的映射（未开启时Handler返回的错误都以200响应），按defaultDbErrorStatus初始化映射
+ */
+func (this *ApiServer) EnableDbErrorStatus() {
+	this.dbErrorStatus = make(map[int]int, len(defaultDbErrorStatus))
+	for kind, status := range defaultDbErrorStatus {
+		this.dbErrorStatus[kind] = status
+	}
+}
+
+/**
+ * 设置Handler返回某类数据库错误（db.DB_ERR_*）时的响应状态码，httpStatus为0时该类错误以200响应。
+ * 未调用EnableDbErrorStatus时只映射通过本方法设置的错误分类
+ */
+func (this *ApiServer) SetDbErrorStatus(errKind, httpStatus int) {
+	if this.dbErrorStatus == nil {
+		this.dbErrorStatus = make(map[int]int)
+	}
+	this.dbErrorStatus[errKind] = httpStatus
+}
+
+/**
+ * 获取Handler返回的错误对应的响应状态码，无法归类为数据库错误时为200
+ */
+func (this *ApiServer) errorHttpStatus(err error) int {
+	if this.dbErrorStatus == nil {
+		return http.StatusOK
+	}
+	if status := this.dbErrorStatus[db.ClassifyError(err)]; status != 0 {
+		return status
+	}
+	return http.StatusOK
+}
+
 /**
  * 将API Server收到的注册路由（函数句柄）同步到底层的Http服务器中
  */
@@ -146,7 +256,10 @@ func (this *ApiServer) registerFuncHandlerRoute() {
 		//促使每个url都配额生效
 		this.GetTokenFunnel().AutocompleteTokenQuota(handlerDef.Path)
 		handleFunc := func(w http.ResponseWriter, r *http.Request) {
-			this.tokenFunnel.GetToken(r.RequestURI, nil)
+			if !this.tokenFunnel.GetTokenWithTimeout(r.RequestURI, nil, this.tokenFunnel.GetMaxWait()) {
+				http.Error(w, http.StatusText(http.StatusTooManyRequests), http.StatusTooManyRequests)
+				return
+			}
 			reqWrapper := new(Request)
 			reqWrapper.SetOriReq(r)
 			respWrapper := new(Response)
@@ -174,12 +287,27 @@ func (this *ApiServer) registerStructHandlerRoute() {
 		structHandler := handlerDef.StructHandler
 		structHandlerType := reflect.TypeOf(structHandler)
 		//注册路由时先尝试检查Handler类型合法性
-		_, ok := reflect.New(structHandlerType).Interface().(IApiHandler)
+		handlerObj, ok := reflect.New(structHandlerType).Interface().(IApiHandler)
 		if !ok {
 			logger.Error("url <%s>'s handler type is illegal: %s", handlerDef.Path, structHandlerType.String())
 			time.Sleep(time.Second) //等待日志控制台输出
 			os.Exit(1)
 		}
+		//在注册阶段解析并校验完整的注入图，避免循环依赖等问题在首个请求时才暴露
+		err := this.validateHandlerInjection(structHandlerType)
+		if err != nil {
+			logger.Error("url <%s>'s %s", handlerDef.Path, err.Error())
+			time.Sleep(time.Second) //等待日志控制台输出
+			os.Exit(1)
+		}
+		//注入计划只在注册时生成一次，之后每次请求直接复用
+		handlerDef.injectPlan, err = this.buildHandlerInjectPlan(structHandlerType)
+		if err != nil {
+			logger.Error("url <%s>'s %s", handlerDef.Path, err.Error())
+			time.Sleep(time.Second) //等待日志控制台输出
+			os.Exit(1)
+		}
+		transactional := isTransactionalRoute(handlerDef, handlerObj)
 		//促使每个url都配额生效
 		this.GetTokenFunnel().AutocompleteTokenQuota(handlerDef.Path)
 		handleFunc := func(w http.ResponseWriter, r *http.Request) {
@@ -189,23 +317,46 @@ func (this *ApiServer) registerStructHandlerRoute() {
 			respWrapper.Init()
 			respWrapper.SetOriResp(w)
 			ctx := this.constructContext(reqWrapper)
+			ctx.sqlLogMode = int(atomic.LoadInt32(&handlerDef.sqlLogMode))
 			reqWrapper.setContext(ctx)
 
-			this.GetTokenFunnel().GetToken(r.URL.Path, ctx)
-			//每次请求需要生成一个新的Handler对象，避免上下文对象被多个请求共享
-			newStructHandlerVal := reflect.New(structHandlerType)
-			if r.Method != http.MethodGet && !strings.Contains(r.Header.Get("Content-Type"), "multipart") {
-				//将Body的JSON数据组装到Handler数据字段中，但是文件上传时multipart格式的Form则不做此处理，否则Body被读取后就无法再读取里面的文件内容
-				this.assembleRequestDataToHandler(newStructHandlerVal, reqWrapper)
+			if !this.GetTokenFunnel().GetTokenWithTimeout(r.URL.Path, ctx, this.GetTokenFunnel().GetMaxWait()) {
+				respWrapper.JsonResponseWithCode(http.StatusTooManyRequests, fmt.Sprintf("error <%s> access token of %s is exhausted", ctx.GetRequestId(), r.URL.Path))
+				return
 			}
-			//组装Handler内声明的所有Service Field
-			newStructHandlerVal = this.assembleServiceToHandler(newStructHandlerVal, ctx)
-			newStructHandler := newStructHandlerVal.Interface().(IApiHandler)
-			newStructHandler.setContext(ctx)
-			newStructHandler.setReqAndResp(reqWrapper, respWrapper)
-			newStructHandler.Init()
-			headerInterceptor := this.assembleInterceptors(newStructHandler, ctx, reqWrapper, respWrapper)
-			this.callStructHandler(headerInterceptor, ctx, reqWrapper, respWrapper)
+			//构造Handler前创建实例容器，使构造过程中panic时已经构造的对象同样能被销毁
+			scope := newServiceScope(ctx)
+			var handleErr error
+			defer func() {
+				panicErr := recover()
+				if panicErr != nil {
+					logger.Error("%s unhandled error: %v", ctx.GetRequestId(), panicErr)
+					debug.PrintStack()
+					handleErr = fmt.Errorf("unhandled error: %v", panicErr)
+					respWrapper.JsonResponse(fmt.Sprintf("unhandled error <%s> %v", ctx.GetRequestId(), panicErr))
+				}
+				//响应写回后，按构造的逆序销毁本次请求生成的Handler、Service及DB操作对象
+				scope.destroy(handleErr)
+			}()
+			handler := this.newStructHandler(handlerDef, reqWrapper, respWrapper, ctx, scope)
+			if transactional {
+				//声明式事务在Handler构造完成后开启，Service及DB操作对象在使用连接时通过请求上下文的事务栈加入该事务
+				routeTx, err := this.beginRouteTransaction(ctx)
+				if err != nil {
+					logger.Error("%s %s", ctx.GetRequestId(), err.Error())
+					handleErr = err
+					respWrapper.JsonResponseWithCode(this.errorHttpStatus(err), fmt.Sprintf("error <%s> %v", ctx.GetRequestId(), err))
+					return
+				}
+				//请求被拦截器提前拦截或处理过程中panic时事务尚未结束，此处统一回滚
+				defer routeTx.finish(errors.New("request did not reach handler"))
+				txHandlerObj := &txHandler{handler: handler, routeTx: routeTx}
+				txHandlerObj.setContext(ctx)
+				txHandlerObj.setReqAndResp(reqWrapper, respWrapper)
+				handler = txHandlerObj
+			}
+			headerInterceptor := this.assembleInterceptors(handler, ctx, reqWrapper, respWrapper)
+			handleErr = this.callStructHandler(headerInterceptor, ctx, reqWrapper, respWrapper)
 		}
 		if this.printRegisterInfo {
 			logger.Debug("register api struct handler: %d <%s> %s %s", i, handlerDef.Method, handlerDef.Path, structHandlerType.String())
@@ -214,6 +365,30 @@ func (this *ApiServer) registerStructHandlerRoute() {
 	}
 }
 
+/**
+ * 为一次请求生成新的Handler对象，并组装请求数据及Handler内声明的所有Service，构造的对象记录在本次请求的实例容器scope中
+ */
+func (this *ApiServer) newStructHandler(handlerDef *StructHandlerDef, r *Request, w *Response, ctx *RequestContext, scope *serviceScope) IApiHandler {
+	plan := handlerDef.injectPlan
+	//每次请求需要生成一个新的Handler对象，避免上下文对象被多个请求共享
+	newStructHandlerVal := reflect.New(plan.handlerType)
+	oriReq := r.GetOriReq()
+	if oriReq.Method != http.MethodGet && !strings.Contains(oriReq.Header.Get("Content-Type"), "multipart") {
+		//将Body的JSON数据组装到Handler数据字段中，但是文件上传时multipart格式的Form则不做此处理，否则Body被读取后就无法再读取里面的文件内容
+		this.assembleRequestDataToHandler(newStructHandlerVal, plan, r)
+	}
+	//注入Handler中声明的组件及配置
+	assembleFixedFields(newStructHandlerVal.Elem(), plan.fixedFields)
+	//组装Handler内声明的所有Service Field
+	newStructHandlerVal = this.assembleServiceToHandler(newStructHandlerVal, plan, scope)
+	newStructHandler := newStructHandlerVal.Interface().(IApiHandler)
+	newStructHandler.setContext(ctx)
+	newStructHandler.setReqAndResp(r, w)
+	newStructHandler.Init()
+	scope.track(newStructHandler)
+	return newStructHandler
+}
+
 /**
  * 基于HTTP请求构造请求上下文对象
  */
@@ -230,33 +405,20 @@ func (this *ApiServer) constructContext(r *Request) *RequestContext {
 /**
  * 将请求数据从HTTP Body封装到Handler的数据对象中
  */
-func (this *ApiServer) assembleRequestDataToHandler(handlerVal reflect.Value, r *Request) reflect.Value {
+func (this *ApiServer) assembleRequestDataToHandler(handlerVal reflect.Value, plan *handlerInjectPlan, r *Request) reflect.Value {
+	if len(plan.dataFields) == 0 {
+		return handlerVal
+	}
 	body, err := r.GetBody()
 	if err != nil {
 		logger.Error("get body json data failed: %s", err.Error())
 		return handlerVal
 	}
 	handlerElem := handlerVal.Elem()
-	for i := 0; i < handlerElem.NumField(); i++ {
-		handlerFieldVal := handlerElem.Field(i)
-		if !handlerFieldVal.IsValid() || !handlerFieldVal.CanInterface() {
-			continue
-		}
-		handlerFieldType := handlerFieldVal.Type()
-		if handlerFieldType.Kind() != reflect.Ptr {
-			continue
-		}
-		//Go中父类也会被子类当成Field遍历出来，需要跳过对父类属性的重生成
-		if strings.Contains(handlerFieldType.String(), "BaseHandler") {
-			continue
-		}
+	for _, fieldIndex := range plan.dataFields {
+		handlerFieldVal := handlerElem.Field(fieldIndex)
 		//获取Handler中声明的公开变量类型，并通过反射的方式为其注入实例
-		fieldVal := reflect.New(handlerFieldType.Elem())
-		_, ok := fieldVal.Interface().(IApiService)
-		//跳过对Service字段的处理
-		if ok {
-			continue
-		}
+		fieldVal := reflect.New(handlerFieldVal.Type().Elem())
 		filedObj := fieldVal.Interface()
 		err := json.Unmarshal(body, filedObj)
 		if err != nil {
@@ -271,38 +433,13 @@ func (this *ApiServer) assembleRequestDataToHandler(handlerVal reflect.Value, r
 /**
  * 组装Handler中的Service对象
  */
-func (this *ApiServer) assembleServiceToHandler(handlerVal reflect.Value, ctx *RequestContext) reflect.Value {
+func (this *ApiServer) assembleServiceToHandler(handlerVal reflect.Value, plan *handlerInjectPlan, scope *serviceScope) reflect.Value {
 	handlerElem := handlerVal.Elem()
-	for i := 0; i < handlerElem.NumField(); i++ {
-		handlerFieldVal := handlerElem.Field(i)
-		if !handlerFieldVal.IsValid() || !handlerFieldVal.CanInterface() {
-			continue
-		}
-		handlerFieldType := handlerFieldVal.Type()
-		//为了确保Service与DB相互引用时，指向的是同一个Service， 必须要求成员Field为指针类型
-		if handlerFieldType.Kind() != reflect.Ptr {
-			continue
-		}
-		//Go中父类也会被子类当成Field遍历出来，需要跳过对父类属性的重生成
-		if strings.Contains(handlerFieldType.String(), "BaseHandler") {
-			continue
-		}
-		//获取Handler中声明的公开变量类型，并通过反射的方式为其注入实例
-		serviceVal := reflect.New(handlerFieldType.Elem())
-		serviceObj, ok := serviceVal.Interface().(IApiService)
-		if !ok {
-			continue
-		}
-
-		serviceObj.setContext(ctx)
-		serviceObj.SetOrmConn(this.ormConn)
-		//组装Service中的DB操作对象
-		serviceVal = this.assembleDbToService(serviceObj, serviceVal, ctx)
-		//组织Service间的横向引用
-		serviceVal = this.assembleServiceToService(serviceObj, serviceVal, ctx)
-		serviceObj.Init()
+	for _, fieldPlan := range plan.serviceFields {
+		//按作用域获取Service实例，同一请求内相同类型的Service共享同一个实例（及其事务）
+		serviceVal := this.resolveService(fieldPlan, DEFAULT_DATA_SOURCE, scope)
 		//logger.Debug("%s assemble service <%p-%s> to handler <%s>'s field", ctx.GetRequestId(), &serviceObj, handlerFieldType.Name(), handlerElem.Type().Name())
-		handlerFieldVal.Set(serviceVal)
+		handlerElem.Field(fieldPlan.fieldIndex).Set(serviceVal)
 	}
 	return handlerVal
 }
@@ -310,82 +447,46 @@ func (this *ApiServer) assembleServiceToHandler(handlerVal reflect.Value, ctx *R
 /**
  * 20211209：由于Service之间可能会横向调用，因此特增加本方法
  */
-func (this *ApiServer) assembleServiceToService(serviceObj IApiService, serviceVal reflect.Value, ctx *RequestContext) reflect.Value {
+func (this *ApiServer) assembleServiceToService(serviceObj IApiService, serviceVal reflect.Value, plan *serviceInjectPlan, scope *serviceScope) reflect.Value {
 	serviceElem := serviceVal.Elem()
-	for i := 0; i < serviceElem.NumField(); i++ {
-		serviceFieldVal := serviceElem.Field(i)
-		if !serviceFieldVal.IsValid() || !serviceFieldVal.CanInterface() {
-			continue
-		}
-		serviceFieldType := serviceFieldVal.Type()
-		//为了确保Service与DB相互引用时，指向的是同一个Service， 必须要求成员Field为指针类型
-		if serviceFieldType.Kind() != reflect.Ptr {
-			continue
-		}
-		//Go中父类也会被子类当成Field遍历出来，需要跳过对父类属性的重生成
-		if strings.Contains(serviceFieldType.String(), "BaseService") {
-			continue
-		}
-		refServiceVal := reflect.New(serviceFieldType.Elem())
-		refServiceObj, ok := refServiceVal.Interface().(IApiService)
-		if !ok {
-			continue
-		}
-
-		refServiceObj.setContext(ctx)
-		refServiceObj.SetOrmConn(serviceObj.GetOrmConn())
-		//组装二次引用Service中的DB操作对象
-		refServiceVal = this.assembleDbToService(refServiceObj, refServiceVal, ctx)
-		//如果Service中又递归引用了其它Service，则一直继续初始化下去
-		refServiceVal = this.assembleServiceToService(refServiceObj, refServiceVal, ctx)
-		refServiceObj.Init()
-		serviceFieldVal.Set(refServiceVal)
+	for _, fieldPlan := range plan.serviceFields {
+		//如果Service中又递归引用了其它Service，则由resolveService一直继续初始化下去
+		refServiceVal := this.resolveService(fieldPlan, serviceObj.GetDataSourceName(), scope)
+		serviceElem.Field(fieldPlan.fieldIndex).Set(refServiceVal)
 	}
-
 	return serviceVal
 }
 
 /**
  * 组装Service中的DB操作对象
  */
-func (this *ApiServer) assembleDbToService(serviceObj IApiService, serviceVal reflect.Value, ctx *RequestContext) reflect.Value {
+func (this *ApiServer) assembleDbToService(serviceObj IApiService, serviceVal reflect.Value, plan *serviceInjectPlan, scope *serviceScope) reflect.Value {
 	serviceElem := serviceVal.Elem()
-	for i := 0; i < serviceElem.NumField(); i++ {
-		serviceFieldVal := serviceElem.Field(i)
-		if !serviceFieldVal.IsValid() || !serviceFieldVal.CanInterface() {
-			continue
-		}
-		serviceFieldType := serviceFieldVal.Type()
-		//为了确保Service与DB相互引用时，指向的是同一个Service， 必须要求成员Field为指针类型
-		if serviceFieldType.Kind() != reflect.Ptr {
-			continue
-		}
-		//Go中父类也会被子类当成Field遍历出来，需要跳过对父类属性的重生成
-		if strings.Contains(serviceFieldType.String(), "BaseService") {
-			continue
-		}
-		dbVal := reflect.New(serviceFieldType.Elem())
-		dbObj, ok := dbVal.Interface().(IApiDbOperator)
-		if !ok {
-			continue
-		}
+	for _, fieldPlan := range plan.dbFields {
+		serviceFieldVal := serviceElem.Field(fieldPlan.fieldIndex)
+		dbVal := reflect.New(fieldPlan.dbOperator.dbOperatorType)
+		dbObj := dbVal.Interface().(IApiDbOperator)
+		assembleFixedFields(dbVal.Elem(), fieldPlan.dbOperator.fixedFields)
 		//logger.Debug("%s assemble db operator <%p-%s> to service <%p-%s>'s field", ctx.GetRequestId(), &dbObj, serviceFieldType.Name(), &serviceObj, serviceElem.Type().Name())
-		dbObj.setContext(ctx)
+		dbObj.setContext(scope.ctx)
+		dbObj.setDataSource(fieldPlan.dataSource)
 		//为了确保一个Service下所有的DB操作属于一个事务，因此需要让DB对象反引Service对象，并从中获取DB连接
 		dbObj.SetService(&serviceObj)
 		dbObj.Init()
+		scope.track(dbObj)
 		serviceFieldVal.Set(dbVal)
 	}
 	return serviceVal
 }
 
 /**
- * 为一个Handler组装拦截器链
+ * 为一个Handler组装拦截器链，routeInterceptors为仅作用于当前路由的拦截器，排在全局拦截器之后
  */
-func (this *ApiServer) assembleInterceptors(handler IApiHandler, ctx *RequestContext, r *Request, w *Response) IApiHandler {
+func (this *ApiServer) assembleInterceptors(handler IApiHandler, ctx *RequestContext, r *Request, w *Response, routeInterceptors ...IApiHandler) IApiHandler {
 	var newInterceptors []IApiHandler
+	interceptors := append(append([]IApiHandler{}, this.interceptors...), routeInterceptors...)
 	//为了防止拦截器对象复用造成数据安全问题，所以每次请求Handler对象的关联拦截器都重新生成一份拦截器实例
-	for _, interceptor := range this.interceptors {
+	for _, interceptor := range interceptors {
 		interceptorType := reflect.TypeOf(interceptor)
 		newInterceptorVal := reflect.New(interceptorType.Elem())
 		newInterceptor := newInterceptorVal.Interface().(IApiHandler)
@@ -413,24 +514,26 @@ func (this *ApiServer) assembleInterceptors(handler IApiHandler, ctx *RequestCon
 }
 
 /**
- * 触发对一个结构体请求句柄的调用
+ * 触发对一个结构体请求句柄的调用，返回Handler的处理错误（未处理的panic也会被转换为错误返回）
  */
-func (this *ApiServer) callStructHandler(interceptorAndHandler IApiHandler, ctx *RequestContext, r *Request, w *Response) {
+func (this *ApiServer) callStructHandler(interceptorAndHandler IApiHandler, ctx *RequestContext, r *Request, w *Response) (handleErr error) {
 	defer func() {
 		err := recover()
 		if err != nil {
 			logger.Error(ctx.reqId+" unhandled error: %v", err)
 			debug.PrintStack()
 			w.JsonResponse(fmt.Sprintf("unhandled error <%s> %v", ctx.GetRequestId(), err))
+			handleErr = fmt.Errorf("unhandled error: %v", err)
 		}
 	}()
 	resp, err := interceptorAndHandler.HandleRequest(r)
 	if w.IsAlreadyResponsed() {
-		return
+		return err
 	}
 	if err != nil {
-		w.JsonResponse(fmt.Sprintf("error <%s> %v", ctx.GetRequestId(), err))
+		w.JsonResponseWithCode(this.errorHttpStatus(err), fmt.Sprintf("error <%s> %v", ctx.GetRequestId(), err))
 	} else {
 		w.JsonResponse(resp)
 	}
+	return err
 }
@@ -0,0 +1,75 @@
+package simpleapi
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+type benchStockDb struct {
+	BaseDbOperator
+}
+
+type benchStockService struct {
+	DB *benchStockDb
+	BaseService
+}
+
+type benchOrderService struct {
+	DB    *benchStockDb
+	Stock *benchStockService
+	BaseService
+}
+
+type benchOrderParam struct {
+	OrderId string `json:"order_id"`
+	Amount  int    `json:"amount"`
+}
+
+type benchOrderHandler struct {
+	Param *benchOrderParam
+	Order *benchOrderService
+	Stock *benchStockService
+	BaseHandler
+}
+
+func (this *benchOrderHandler) HandleRequest(r *Request) (interface{}, error) {
+	return this.Param, nil
+}
+
+/**
+ * 测量每次请求生成Handler并完成数据及Service注入的开销
+ */
+func benchmarkNewStructHandler(b *testing.B, method string) {
+	s := new(ApiServer)
+	s.Init()
+	s.GetTokenFunnel().SetDefaultTokenQuota(10)
+	s.RegisterHandler(method, "/bench", benchOrderHandler{})
+	s.registerStructHandlerRoute()
+	handlerDef := s.structHandlerDef[0]
+	body := []byte(`{"order_id":"o-1","amount":10}`)
+	oriReq := httptest.NewRequest(method, "/bench", bytes.NewReader(body))
+	oriReq.Header.Set("Content-Type", "application/json")
+	reqWrapper := new(Request)
+	reqWrapper.SetOriReq(oriReq)
+	respWrapper := new(Response)
+	respWrapper.Init()
+	respWrapper.SetOriResp(httptest.NewRecorder())
+	ctx := s.constructContext(reqWrapper)
+	reqWrapper.setContext(ctx)
+	//Request会缓存读取过的Body，因此可以在循环中复用同一个请求对象，只测量Handler生成及注入的开销
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		s.newStructHandler(handlerDef, reqWrapper, respWrapper, ctx, newServiceScope(ctx))
+	}
+}
+
+func BenchmarkNewStructHandlerGet(b *testing.B) {
+	benchmarkNewStructHandler(b, http.MethodGet)
+}
+
+func BenchmarkNewStructHandlerPost(b *testing.B) {
+	benchmarkNewStructHandler(b, http.MethodPost)
+}
@@ -0,0 +1,154 @@
+package simpleapi
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/duhaifeng/simpleapi/db"
+	"github.com/go-sql-driver/mysql"
+	"gorm.io/gorm"
+)
+
+type dbErrorTestHandler struct {
+	BaseHandler
+}
+
+func (this *dbErrorTestHandler) HandleRequest(r *Request) (interface{}, error) {
+	return nil, fmt.Errorf("load order failed. %w", gorm.ErrRecordNotFound)
+}
+
+func TestDbErrorHttpStatus(t *testing.T) {
+	s := new(ApiServer)
+	s.Init()
+	s.GetTokenFunnel().SetDefaultTokenQuota(10)
+	s.RegisterHandler(http.MethodGet, "/order", dbErrorTestHandler{})
+	s.registerStructHandlerRoute()
+	s.GetTokenFunnel().fullTokenPerSec()
+	recorder := httptest.NewRecorder()
+	s.httpRouter.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/order", nil))
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("db error status is not enabled by default and should respond 200, got %d", recorder.Code)
+	}
+	s.EnableDbErrorStatus()
+	recorder = httptest.NewRecorder()
+	s.httpRouter.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/order", nil))
+	if recorder.Code != http.StatusNotFound {
+		t.Fatalf("not found db error should respond 404, got %d", recorder.Code)
+	}
+	s.SetDbErrorStatus(db.DB_ERR_NOT_FOUND, 0)
+	recorder = httptest.NewRecorder()
+	s.httpRouter.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/order", nil))
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("disabled db error status should respond 200, got %d", recorder.Code)
+	}
+	if s.errorHttpStatus(fmt.Errorf("save order failed. %w", &mysql.MySQLError{Number: db.MYSQL_ERR_DUP_ENTRY})) != http.StatusConflict {
+		t.Fatal("duplicate db error should respond 409")
+	}
+}
+
+type sqlLogTestDb struct {
+	BaseDbOperator
+}
+
+type sqlLogTestService struct {
+	Db *sqlLogTestDb
+	BaseService
+}
+
+type sqlLogTestHandler struct {
+	Service *sqlLogTestService
+	BaseHandler
+}
+
+func (this *sqlLogTestHandler) HandleRequest(r *Request) (interface{}, error) {
+	var count int64
+	err := this.Service.Db.OrmConn().Raw("SELECT 1").Scan(&count).Error
+	return count, err
+}
+
+/**
+ * 记录实际输出了哪些语句的SQL日志适配器，SqlLogger只在需要输出语句时才获取SQL
+ */
+type recordingSqlLogger struct {
+	*db.SqlLogger
+	statements []string
+	lock       sync.Mutex
+}
+
+func (this *recordingSqlLogger) Trace(ctx context.Context, begin time.Time, fc func() (string, int64), err error) {
+	this.SqlLogger.Trace(ctx, begin, func() (string, int64) {
+		sql, rows := fc()
+		this.lock.Lock()
+		this.statements = append(this.statements, sql)
+		this.lock.Unlock()
+		return sql, rows
+	}, err)
+}
+
+func (this *recordingSqlLogger) count() int {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	return len(this.statements)
+}
+
+func TestRouteSqlLog(t *testing.T) {
+	s := new(ApiServer)
+	s.Init()
+	s.GetTokenFunnel().SetDefaultTokenQuota(100)
+	conn := newTestDataSource(t)
+	sqlLogger := &recordingSqlLogger{SqlLogger: &db.SqlLogger{}}
+	conn.SetSqlLogger(sqlLogger)
+	s.RegisterDataSource(DEFAULT_DATA_SOURCE, conn)
+	s.RegisterHandler(http.MethodGet, "/count", sqlLogTestHandler{})
+	s.registerStructHandlerRoute()
+	s.GetTokenFunnel().fullTokenPerSec()
+	serve := func() {
+		recorder := httptest.NewRecorder()
+		s.httpRouter.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/count", nil))
+		if recorder.Code != http.StatusOK {
+			t.Fatalf("count request failed: %d %s", recorder.Code, recorder.Body.String())
+		}
+	}
+
+	serve()
+	if sqlLogger.count() != 0 {
+		t.Fatal("statements should not be logged by default")
+	}
+	err := s.SetRouteSqlLog(http.MethodGet, "/count", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	serve()
+	if sqlLogger.count() != 1 || !strings.Contains(sqlLogger.statements[0], "SELECT 1") {
+		t.Fatalf("statement of the route should be logged: %v", sqlLogger.statements)
+	}
+
+	//运行中切换路由的SQL日志开关不能与处理中的请求产生竞争
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 10; i++ {
+			s.SetRouteSqlLog(http.MethodGet, "/count", i%2 == 0)
+		}
+	}()
+	for i := 0; i < 10; i++ {
+		serve()
+	}
+	wg.Wait()
+	s.SetRouteSqlLog(http.MethodGet, "/count", false)
+	logged := sqlLogger.count()
+	serve()
+	if sqlLogger.count() != logged {
+		t.Fatal("statements should not be logged after the route sql log is turned off")
+	}
+	if s.SetRouteSqlLog(http.MethodGet, "/missing", true) == nil {
+		t.Fatal("unregistered route should be reported")
+	}
+}
@@ -0,0 +1,302 @@
+package simpleapi
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+/**
+ * Handler的注入计划：在注册路由时对Handler类型做一次反射分析，之后每次请求按计划直接注入，不再逐个Field反射判断
+ */
+type handlerInjectPlan struct {
+	handlerType   reflect.Type
+	dataFields    []int //需要从Body的JSON数据中组装的Field下标
+	fixedFields   []*fixedFieldPlan
+	serviceFields []*serviceFieldPlan
+}
+
+/**
+ * 注入固定值的Field（Provide注册的组件及config配置），值在生成计划时已确定，每次请求直接赋值
+ */
+type fixedFieldPlan struct {
+	fieldIndex int
+	value      reflect.Value
+}
+
+/**
+ * 一个Service Field的注入计划
+ */
+type serviceFieldPlan struct {
+	fieldIndex int
+	scope      string
+	dataSource string //为空时继承引用方的数据源，Handler引用时为默认数据源
+	service    *serviceInjectPlan
+}
+
+/**
+ * Service类型的注入计划，同一Service类型在整个ApiServer中只分析一次
+ */
+type serviceInjectPlan struct {
+	serviceType   reflect.Type
+	fixedFields   []*fixedFieldPlan
+	dbFields      []*dbFieldPlan
+	serviceFields []*serviceFieldPlan
+}
+
+/**
+ * 一个DB操作对象Field的注入计划
+ */
+type dbFieldPlan struct {
+	fieldIndex int
+	dataSource string //为空时使用所属Service的数据源
+	dbOperator *dbOperatorInjectPlan
+}
+
+/**
+ * DB操作对象类型的注入计划
+ */
+type dbOperatorInjectPlan struct {
+	dbOperatorType reflect.Type
+	fixedFields    []*fixedFieldPlan
+}
+
+/**
+ * 注入计划生成器，收集生成过程中发现的无法满足的依赖
+ */
+type injectPlanBuilder struct {
+	server *ApiServer
+	errs   []string
+}
+
+/**
+ * 分析Handler类型并生成注入计划（调用前需要已经通过validateHandlerInjection的校验，保证不存在循环依赖）
+ */
+func (this *ApiServer) buildHandlerInjectPlan(handlerType reflect.Type) (*handlerInjectPlan, error) {
+	builder := &injectPlanBuilder{server: this}
+	plan := new(handlerInjectPlan)
+	plan.handlerType = handlerType
+	for i := 0; i < handlerType.NumField(); i++ {
+		field := handlerType.Field(i)
+		//Go中父类也会被子类当成Field遍历出来，需要跳过对父类属性的重生成
+		if field.Anonymous {
+			continue
+		}
+		fixedField := builder.buildFixedFieldPlan(handlerType, field, i)
+		if fixedField != nil {
+			plan.fixedFields = append(plan.fixedFields, fixedField)
+			continue
+		}
+		//未导出的Field无法通过反射赋值
+		if field.PkgPath != "" {
+			continue
+		}
+		if field.Type.Kind() == reflect.Interface {
+			serviceField := builder.buildBoundServiceFieldPlan(handlerType, field, i)
+			if serviceField != nil {
+				plan.serviceFields = append(plan.serviceFields, serviceField)
+			}
+			continue
+		}
+		if field.Type.Kind() != reflect.Ptr {
+			continue
+		}
+		fieldElemType := field.Type.Elem()
+		if fieldElemType.Kind() == reflect.Struct && isServiceType(fieldElemType) {
+			plan.serviceFields = append(plan.serviceFields, builder.buildServiceFieldPlan(handlerType, field, fieldElemType, i))
+			continue
+		}
+		if fieldElemType.Kind() == reflect.Struct && isDbOperatorType(fieldElemType) {
+			continue
+		}
+		plan.dataFields = append(plan.dataFields, i)
+	}
+	if len(builder.errs) > 0 {
+		return nil, fmt.Errorf("unsatisfied dependency of %s: %s", handlerType.String(), strings.Join(builder.errs, "; "))
+	}
+	return plan, nil
+}
+
+/**
+ * 生成一个Service Field的注入计划，Field的作用域及数据源在此时确定。serviceType为实际实例化的Service结构体类型
+ */
+func (this *injectPlanBuilder) buildServiceFieldPlan(ownerType reflect.Type, field reflect.StructField, serviceType reflect.Type, fieldIndex int) *serviceFieldPlan {
+	fieldPlan := new(serviceFieldPlan)
+	fieldPlan.fieldIndex = fieldIndex
+	fieldPlan.scope = this.server.getServiceScope(serviceType, field.Tag)
+	fieldPlan.dataSource = getDataSourceName(serviceType, field.Tag)
+	if fieldPlan.dataSource != "" {
+		err := this.server.checkDataSource(fieldPlan.dataSource)
+		if err != nil {
+			this.addError(ownerType, field, err.Error())
+		}
+	}
+	fieldPlan.service = this.buildServiceInjectPlan(serviceType)
+	return fieldPlan
+}
+
+/**
+ * 为接口类型的Field生成注入计划：接口通过Bind绑定了Service实现时按实现类型注入；
+ * 未绑定但接口包含IApiService方法集的Field视为无法满足的依赖。返回nil表示该Field不是Service接口Field
+ */
+func (this *injectPlanBuilder) buildBoundServiceFieldPlan(ownerType reflect.Type, field reflect.StructField, fieldIndex int) *serviceFieldPlan {
+	serviceType, ok := this.server.resolveServiceBinding(field.Type)
+	if ok {
+		return this.buildServiceFieldPlan(ownerType, field, serviceType, fieldIndex)
+	}
+	if field.Type.Implements(apiServiceType) {
+		this.addError(ownerType, field, "no service is bound to the interface")
+	}
+	return nil
+}
+
+/**
+ * 分析Service类型并生成注入计划，已分析过的类型直接复用
+ */
+func (this *injectPlanBuilder) buildServiceInjectPlan(serviceType reflect.Type) *serviceInjectPlan {
+	if this.server.servicePlans == nil {
+		this.server.servicePlans = make(map[reflect.Type]*serviceInjectPlan)
+	}
+	plan, ok := this.server.servicePlans[serviceType]
+	if ok {
+		return plan
+	}
+	plan = new(serviceInjectPlan)
+	plan.serviceType = serviceType
+	this.server.servicePlans[serviceType] = plan
+	for i := 0; i < serviceType.NumField(); i++ {
+		field := serviceType.Field(i)
+		if field.Anonymous {
+			continue
+		}
+		fixedField := this.buildFixedFieldPlan(serviceType, field, i)
+		if fixedField != nil {
+			plan.fixedFields = append(plan.fixedFields, fixedField)
+			continue
+		}
+		if field.PkgPath == "" && field.Type.Kind() == reflect.Interface {
+			serviceField := this.buildBoundServiceFieldPlan(serviceType, field, i)
+			if serviceField != nil {
+				plan.serviceFields = append(plan.serviceFields, serviceField)
+			}
+			continue
+		}
+		if field.PkgPath != "" || field.Type.Kind() != reflect.Ptr || field.Type.Elem().Kind() != reflect.Struct {
+			continue
+		}
+		fieldElemType := field.Type.Elem()
+		if isDbOperatorType(fieldElemType) {
+			dataSource := getDataSourceName(fieldElemType, field.Tag)
+			if dataSource != "" {
+				err := this.server.checkDataSource(dataSource)
+				if err != nil {
+					this.addError(serviceType, field, err.Error())
+				}
+			}
+			plan.dbFields = append(plan.dbFields, &dbFieldPlan{fieldIndex: i, dataSource: dataSource, dbOperator: this.buildDbOperatorInjectPlan(fieldElemType)})
+		} else if isServiceType(fieldElemType) {
+			plan.serviceFields = append(plan.serviceFields, this.buildServiceFieldPlan(serviceType, field, fieldElemType, i))
+		}
+	}
+	return plan
+}
+
+/**
+ * 分析DB操作对象类型并生成注入计划，已分析过的类型直接复用
+ */
+func (this *injectPlanBuilder) buildDbOperatorInjectPlan(dbOperatorType reflect.Type) *dbOperatorInjectPlan {
+	if this.server.dbOperatorPlans == nil {
+		this.server.dbOperatorPlans = make(map[reflect.Type]*dbOperatorInjectPlan)
+	}
+	plan, ok := this.server.dbOperatorPlans[dbOperatorType]
+	if ok {
+		return plan
+	}
+	plan = new(dbOperatorInjectPlan)
+	plan.dbOperatorType = dbOperatorType
+	this.server.dbOperatorPlans[dbOperatorType] = plan
+	for i := 0; i < dbOperatorType.NumField(); i++ {
+		field := dbOperatorType.Field(i)
+		if field.Anonymous {
+			continue
+		}
+		fixedField := this.buildFixedFieldPlan(dbOperatorType, field, i)
+		if fixedField != nil {
+			plan.fixedFields = append(plan.fixedFields, fixedField)
+		}
+	}
+	return plan
+}
+
+/**
+ * 分析Field是否需要注入组件或配置：
+ * 1、带有config:"key"标签的Field注入服务器配置
+ * 2、带有inject:"name"标签的Field按名称注入组件，inject:""按类型注入组件（允许匹配唯一实现了接口的组件）
+ * 3、未带标签的公开Field，如果其类型与某个未命名组件的类型完全一致，也会被注入
+ * 返回nil表示该Field不是组件或配置Field
+ */
+func (this *injectPlanBuilder) buildFixedFieldPlan(ownerType reflect.Type, field reflect.StructField, fieldIndex int) *fixedFieldPlan {
+	configKey := field.Tag.Get(CONFIG_INJECT_TAG)
+	componentName, hasInjectTag := field.Tag.Lookup(COMPONENT_INJECT_TAG)
+	if configKey == "" && !hasInjectTag {
+		if field.PkgPath != "" {
+			return nil
+		}
+		componentVal, ok, err := this.server.getComponentByType(field.Type, false)
+		if err != nil {
+			this.addError(ownerType, field, err.Error())
+			return nil
+		}
+		if !ok {
+			return nil
+		}
+		return &fixedFieldPlan{fieldIndex: fieldIndex, value: componentVal}
+	}
+	if field.PkgPath != "" {
+		this.addError(ownerType, field, "unexported field can not be injected")
+		return nil
+	}
+	var value reflect.Value
+	var err error
+	if configKey != "" {
+		configValue, ok := this.server.GetConfig(configKey)
+		if !ok {
+			this.addError(ownerType, field, fmt.Sprintf("config <%s> is not set", configKey))
+			return nil
+		}
+		value, err = convertConfigValue(configValue, field.Type)
+	} else if componentName != "" {
+		value, err = this.server.getNamedComponent(componentName)
+	} else {
+		var ok bool
+		value, ok, err = this.server.getComponentByType(field.Type, true)
+		if err == nil && !ok {
+			err = fmt.Errorf("component of type %s is not provided", field.Type.String())
+		}
+	}
+	if err != nil {
+		this.addError(ownerType, field, err.Error())
+		return nil
+	}
+	if !value.Type().AssignableTo(field.Type) {
+		this.addError(ownerType, field, fmt.Sprintf("%s is not assignable to the field", value.Type().String()))
+		return nil
+	}
+	return &fixedFieldPlan{fieldIndex: fieldIndex, value: value}
+}
+
+/**
+ * 记录一个Field上无法满足的依赖
+ */
+func (this *injectPlanBuilder) addError(ownerType reflect.Type, field reflect.StructField, reason string) {
+	this.errs = append(this.errs, fmt.Sprintf("%s.%s (%s): %s", ownerType.String(), field.Name, field.Type.String(), reason))
+}
+
+/**
+ * 按计划为对象注入组件及配置
+ */
+func assembleFixedFields(objElem reflect.Value, fixedFields []*fixedFieldPlan) {
+	for _, fixedField := range fixedFields {
+		objElem.Field(fixedField.fieldIndex).Set(fixedField.value)
+	}
+}
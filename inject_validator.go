@@ -0,0 +1,168 @@
+package simpleapi
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+var (
+	apiServiceType    = reflect.TypeOf((*IApiService)(nil)).Elem()
+	apiDbOperatorType = reflect.TypeOf((*IApiDbOperator)(nil)).Elem()
+)
+
+/**
+ * 注入图校验器：在注册路由时遍历Handler -> Service -> DB操作对象的依赖关系，提前发现循环依赖及无法注入的Field
+ */
+type injectValidator struct {
+	server          *ApiServer
+	checkedServices map[reflect.Type]bool //已完成校验的Service类型，避免重复遍历
+	errs            []string
+}
+
+/**
+ * 校验一个Handler类型的完整注入图，发现问题时返回包含所有问题及类型路径的错误
+ */
+func (this *ApiServer) validateHandlerInjection(handlerType reflect.Type) error {
+	validator := new(injectValidator)
+	validator.server = this
+	validator.checkedServices = make(map[reflect.Type]bool)
+	validator.checkHandler(handlerType)
+	if len(validator.errs) == 0 {
+		return nil
+	}
+	return fmt.Errorf("injection graph of %s is illegal: %s", handlerType.String(), strings.Join(validator.errs, "; "))
+}
+
+/**
+ * 校验Handler中声明的Field
+ */
+func (this *injectValidator) checkHandler(handlerType reflect.Type) {
+	path := []reflect.Type{handlerType}
+	for i := 0; i < handlerType.NumField(); i++ {
+		field := handlerType.Field(i)
+		if !this.checkFieldInjectable(field, path) {
+			continue
+		}
+		fieldElemType := this.getFieldTargetType(field)
+		if isDbOperatorType(fieldElemType) {
+			this.addError(path, field, "db operator can only be injected into service")
+			continue
+		}
+		if isServiceType(fieldElemType) {
+			this.checkService(fieldElemType, path)
+		}
+	}
+}
+
+/**
+ * 深度优先校验Service引用的其它Service，path中记录了从Handler到当前Service的类型路径，用于发现循环依赖
+ */
+func (this *injectValidator) checkService(serviceType reflect.Type, path []reflect.Type) {
+	for i, pathType := range path {
+		if pathType == serviceType {
+			cyclePath := append(append([]reflect.Type{}, path[i:]...), serviceType)
+			this.errs = append(this.errs, "dependency cycle: "+formatTypePath(cyclePath))
+			return
+		}
+	}
+	if this.checkedServices[serviceType] {
+		return
+	}
+	path = append(append([]reflect.Type{}, path...), serviceType)
+	for i := 0; i < serviceType.NumField(); i++ {
+		field := serviceType.Field(i)
+		if !this.checkFieldInjectable(field, path) {
+			continue
+		}
+		fieldElemType := this.getFieldTargetType(field)
+		if isServiceType(fieldElemType) {
+			this.checkService(fieldElemType, path)
+		}
+	}
+	this.checkedServices[serviceType] = true
+}
+
+/**
+ * 检查一个Field是否为可注入的Service或DB操作对象：非指针类型、未导出的Field都无法被框架注入，需要作为错误报告。
+ * 返回true表示该Field为合法的指针Field或已绑定实现的接口Field，需要继续校验其指向的类型
+ */
+func (this *injectValidator) checkFieldInjectable(field reflect.StructField, path []reflect.Type) bool {
+	//Go中父类也会被子类当成Field遍历出来，跳过对嵌入基类的处理
+	if field.Anonymous {
+		return false
+	}
+	fieldType := field.Type
+	if fieldType.Kind() == reflect.Struct {
+		if isServiceType(fieldType) || isDbOperatorType(fieldType) {
+			this.addError(path, field, "service and db operator field must be a pointer")
+		}
+		return false
+	}
+	//接口类型的Field需要通过Bind绑定到具体的Service实现
+	if fieldType.Kind() == reflect.Interface {
+		_, ok := this.server.resolveServiceBinding(fieldType)
+		if !ok {
+			return false
+		}
+		if field.PkgPath != "" {
+			this.addError(path, field, "unexported field can not be injected")
+			return false
+		}
+		return true
+	}
+	if fieldType.Kind() != reflect.Ptr || fieldType.Elem().Kind() != reflect.Struct {
+		return false
+	}
+	if !isServiceType(fieldType.Elem()) && !isDbOperatorType(fieldType.Elem()) {
+		return false
+	}
+	if field.PkgPath != "" {
+		this.addError(path, field, "unexported field can not be injected")
+		return false
+	}
+	return true
+}
+
+/**
+ * 获取Field实际注入的结构体类型：指针Field为其指向的类型，接口Field为其绑定的Service实现类型
+ */
+func (this *injectValidator) getFieldTargetType(field reflect.StructField) reflect.Type {
+	if field.Type.Kind() == reflect.Interface {
+		serviceType, _ := this.server.resolveServiceBinding(field.Type)
+		return serviceType
+	}
+	return field.Type.Elem()
+}
+
+/**
+ * 记录一个Field上的校验错误
+ */
+func (this *injectValidator) addError(path []reflect.Type, field reflect.StructField, reason string) {
+	this.errs = append(this.errs, fmt.Sprintf("%s.%s (%s): %s", formatTypePath(path), field.Name, field.Type.String(), reason))
+}
+
+/**
+ * 判断一个结构体类型的指针是否实现了IApiService接口
+ */
+func isServiceType(structType reflect.Type) bool {
+	return reflect.PtrTo(structType).Implements(apiServiceType)
+}
+
+/**
+ * 判断一个结构体类型的指针是否实现了IApiDbOperator接口
+ */
+func isDbOperatorType(structType reflect.Type) bool {
+	return reflect.PtrTo(structType).Implements(apiDbOperatorType)
+}
+
+/**
+ * 将类型路径格式化为 A -> B -> C 的形式
+ */
+func formatTypePath(path []reflect.Type) string {
+	names := make([]string, 0, len(path))
+	for _, pathType := range path {
+		names = append(names, pathType.String())
+	}
+	return strings.Join(names, " -> ")
+}
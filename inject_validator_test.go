@@ -0,0 +1,34 @@
+package simpleapi
+
+import (
+	"reflect"
+	"testing"
+)
+
+type cycleTestServiceA struct {
+	B *cycleTestServiceB
+	BaseService
+}
+
+type cycleTestServiceB struct {
+	A *cycleTestServiceA
+	BaseService
+}
+
+type cycleTestHandler struct {
+	A     *cycleTestServiceA
+	Stock scopeTestStockService
+	BaseHandler
+}
+
+func TestInjectionGraphValidation(t *testing.T) {
+	err := new(ApiServer).validateHandlerInjection(reflect.TypeOf(scopeTestHandler{}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = new(ApiServer).validateHandlerInjection(reflect.TypeOf(cycleTestHandler{}))
+	if err == nil {
+		t.Fatal("dependency cycle should be detected")
+	}
+	t.Log(err)
+}
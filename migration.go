@@ -0,0 +1,35 @@
+package simpleapi
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/duhaifeng/simpleapi/db"
+)
+
+/**
+ * 要求启动服务器前迁移管理器中的所有迁移都已经执行，否则StartListen拒绝启动
+ */
+func (this *ApiServer) RequireMigrations(migrators ...*db.Migrator) {
+	this.requiredMigrators = append(this.requiredMigrators, migrators...)
+}
+
+/**
+ * 检查是否存在尚未执行的数据库迁移
+ */
+func (this *ApiServer) checkMigrations() error {
+	for _, migrator := range this.requiredMigrators {
+		pending, err := migrator.Pending()
+		if err != nil {
+			return fmt.Errorf("check migrations failed: %w", err)
+		}
+		if len(pending) > 0 {
+			versions := make([]string, len(pending))
+			for i, migration := range pending {
+				versions[i] = fmt.Sprintf("%d_%s", migration.Version, migration.Name)
+			}
+			return fmt.Errorf("migrations are pending: %s", strings.Join(versions, ", "))
+		}
+	}
+	return nil
+}
@@ -0,0 +1,41 @@
+package simpleapi
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/duhaifeng/simpleapi/db"
+)
+
+func TestRequireMigrations(t *testing.T) {
+	s := new(ApiServer)
+	s.Init()
+	if s.checkMigrations() != nil {
+		t.Fatal("server without migrators should start")
+	}
+	s.RequireMigrations(db.NewMigrator(&db.GormProxy{}))
+	if s.checkMigrations() == nil {
+		t.Fatal("unchecked migrations should refuse to start")
+	}
+
+	conn := newTestDataSource(t)
+	migrator := db.NewMigrator(conn)
+	migrator.RegisterSql(1, "create_account", "CREATE TABLE account (id INTEGER PRIMARY KEY);", "")
+	s = new(ApiServer)
+	s.Init()
+	s.RequireMigrations(migrator)
+	err := s.checkMigrations()
+	if err == nil || !strings.Contains(err.Error(), "1_create_account") {
+		t.Fatalf("pending migrations should refuse to start: %v", err)
+	}
+	if conn.Conn.Migrator().HasTable(db.DEFAULT_MIGRATION_TABLE) {
+		t.Fatal("startup check should not create the migration history table")
+	}
+	_, err = migrator.Up()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.checkMigrations() != nil {
+		t.Fatal("server should start after all migrations are applied")
+	}
+}
@@ -0,0 +1,89 @@
+package simpleapi
+
+import (
+	"fmt"
+	"reflect"
+)
+
+var reflectTypeType = reflect.TypeOf((*reflect.Type)(nil)).Elem()
+
+/**
+ * 将接口类型的Service Field绑定到具体的Service实现，用于在不同环境中替换实现（例如预发环境使用模拟的支付服务）。
+ * 参数可以传入reflect.Type，也可以传入类型指针，例如：Bind((*PaymentService)(nil), (*FakePaymentService)(nil))
+ */
+func (this *ApiServer) Bind(interfaceType interface{}, implType interface{}) {
+	this.BindInEnv("", interfaceType, implType)
+}
+
+/**
+ * 向指定环境的绑定集合中添加绑定，启动时通过SetBindingEnv选择生效的环境，未在该环境中绑定的接口使用默认绑定
+ */
+func (this *ApiServer) BindInEnv(env string, interfaceType interface{}, implType interface{}) {
+	ifaceType := toBindingType(interfaceType)
+	if ifaceType.Kind() == reflect.Ptr {
+		ifaceType = ifaceType.Elem()
+	}
+	if ifaceType.Kind() != reflect.Interface {
+		panic(fmt.Sprintf("bind target %s is not an interface", ifaceType.String()))
+	}
+	serviceType := toBindingType(implType)
+	if serviceType.Kind() == reflect.Ptr {
+		serviceType = serviceType.Elem()
+	}
+	if serviceType.Kind() != reflect.Struct || !isServiceType(serviceType) {
+		panic(fmt.Sprintf("bind implementation %s is not a service", serviceType.String()))
+	}
+	if !reflect.PtrTo(serviceType).Implements(ifaceType) {
+		panic(fmt.Sprintf("*%s does not implement %s", serviceType.String(), ifaceType.String()))
+	}
+	if this.bindings == nil {
+		this.bindings = make(map[string]map[reflect.Type]reflect.Type)
+	}
+	envBindings, ok := this.bindings[env]
+	if !ok {
+		envBindings = make(map[reflect.Type]reflect.Type)
+		this.bindings[env] = envBindings
+	}
+	envBindings[ifaceType] = serviceType
+}
+
+/**
+ * 选择生效的绑定环境，需要在StartListen之前调用
+ */
+func (this *ApiServer) SetBindingEnv(env string) {
+	this.bindingEnv = env
+}
+
+/**
+ * 获取当前生效的绑定环境
+ */
+func (this *ApiServer) GetBindingEnv() string {
+	return this.bindingEnv
+}
+
+/**
+ * 查找接口类型绑定的Service实现类型，当前环境的绑定优先于默认绑定
+ */
+func (this *ApiServer) resolveServiceBinding(ifaceType reflect.Type) (reflect.Type, bool) {
+	if this.bindingEnv != "" {
+		serviceType, ok := this.bindings[this.bindingEnv][ifaceType]
+		if ok {
+			return serviceType, true
+		}
+	}
+	serviceType, ok := this.bindings[""][ifaceType]
+	return serviceType, ok
+}
+
+/**
+ * 将绑定参数统一转换为reflect.Type
+ */
+func toBindingType(typeOrPtr interface{}) reflect.Type {
+	if typeOrPtr == nil {
+		panic("binding type is nil")
+	}
+	if reflect.TypeOf(typeOrPtr).Implements(reflectTypeType) {
+		return typeOrPtr.(reflect.Type)
+	}
+	return reflect.TypeOf(typeOrPtr)
+}
@@ -0,0 +1,87 @@
+package simpleapi
+
+import (
+	"reflect"
+	"testing"
+)
+
+type bindingTestPaymentService interface {
+	IApiService
+	Pay(amount int) string
+}
+
+type bindingTestRealPayment struct {
+	BaseService
+}
+
+func (this *bindingTestRealPayment) Pay(amount int) string {
+	return "real"
+}
+
+type bindingTestFakePayment struct {
+	BaseService
+}
+
+func (this *bindingTestFakePayment) Pay(amount int) string {
+	return "fake"
+}
+
+type bindingTestOrderService struct {
+	Payment bindingTestPaymentService
+	BaseService
+}
+
+type bindingTestHandler struct {
+	Order   *bindingTestOrderService
+	Payment bindingTestPaymentService
+	BaseHandler
+}
+
+func (this *bindingTestHandler) HandleRequest(r *Request) (interface{}, error) {
+	return nil, nil
+}
+
+func newBindingTestHandler(t *testing.T, s *ApiServer) *bindingTestHandler {
+	handlerType := reflect.TypeOf(bindingTestHandler{})
+	err := s.validateHandlerInjection(handlerType)
+	if err != nil {
+		t.Fatal(err)
+	}
+	plan, err := s.buildHandlerInjectPlan(handlerType)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := new(RequestContext)
+	ctx.Init()
+	return s.assembleServiceToHandler(reflect.New(plan.handlerType), plan, newServiceScope(ctx)).Interface().(*bindingTestHandler)
+}
+
+func TestInterfaceServiceBinding(t *testing.T) {
+	s := new(ApiServer)
+	s.Init()
+	s.Bind((*bindingTestPaymentService)(nil), (*bindingTestRealPayment)(nil))
+	s.BindInEnv("staging", reflect.TypeOf((*bindingTestPaymentService)(nil)).Elem(), reflect.TypeOf(bindingTestFakePayment{}))
+	handler := newBindingTestHandler(t, s)
+	if handler.Payment.Pay(1) != "real" || handler.Order.Payment != handler.Payment {
+		t.Fatal("default binding should be shared in one request")
+	}
+
+	s = new(ApiServer)
+	s.Init()
+	s.Bind((*bindingTestPaymentService)(nil), (*bindingTestRealPayment)(nil))
+	s.BindInEnv("staging", (*bindingTestPaymentService)(nil), (*bindingTestFakePayment)(nil))
+	s.SetBindingEnv("staging")
+	handler = newBindingTestHandler(t, s)
+	if handler.Payment.Pay(1) != "fake" {
+		t.Fatal("staging binding should override default binding")
+	}
+}
+
+func TestUnboundServiceInterface(t *testing.T) {
+	s := new(ApiServer)
+	s.Init()
+	_, err := s.buildHandlerInjectPlan(reflect.TypeOf(bindingTestHandler{}))
+	if err == nil {
+		t.Fatal("unbound service interface should be reported")
+	}
+}
@@ -0,0 +1,205 @@
+package simpleapi
+
+import (
+	"fmt"
+	"reflect"
+	"sync"
+)
+
+/**
+ * 一次请求内的Service实例容器：同一请求中相同类型（及数据源）的request作用域Service只实例化一次，并在整个注入图中共享
+ */
+type serviceScope struct {
+	ctx          *RequestContext
+	singleton    bool //是否为单例Service的实例容器，容器内的Service不绑定请求，不能开启事务
+	services     map[serviceKey]reflect.Value
+	destroyables []IApiDestroyable //按构造顺序记录的需要销毁的对象
+}
+
+/**
+ * 单例Service的实例，同一类型（及数据源）的单例只构造一次
+ */
+type singletonService struct {
+	once       sync.Once
+	serviceVal reflect.Value
+}
+
+/**
+ * Service实例的共享标识，同一类型的Service使用不同数据源时是不同的实例
+ */
+type serviceKey struct {
+	serviceType reflect.Type
+	dataSource  string
+}
+
+/**
+ * 创建一个请求级的Service实例容器
+ */
+func newServiceScope(ctx *RequestContext) *serviceScope {
+	scope := new(serviceScope)
+	scope.ctx = ctx
+	scope.services = make(map[serviceKey]reflect.Value)
+	return scope
+}
+
+/**
+ * 创建一个单例Service的实例容器，单例依赖的request作用域Service同样归属于该容器
+ */
+func newSingletonScope() *serviceScope {
+	scope := newServiceScope(nil)
+	scope.singleton = true
+	return scope
+}
+
+/**
+ * 记录一个已完成初始化的对象，如果对象实现了IApiDestroyable接口，则在请求结束时销毁
+ */
+func (this *serviceScope) track(obj interface{}) {
+	destroyable, ok := obj.(IApiDestroyable)
+	if ok {
+		this.destroyables = append(this.destroyables, destroyable)
+	}
+}
+
+/**
+ * 按构造的逆序调用对象的Destroy方法，单个对象销毁时的panic会被隔离并记录日志，不影响其它对象的销毁
+ */
+func (this *serviceScope) destroy(err error) {
+	for i := len(this.destroyables) - 1; i >= 0; i-- {
+		this.destroyOne(this.destroyables[i], err)
+	}
+	this.destroyables = nil
+}
+
+/**
+ * 销毁单个对象
+ */
+func (this *serviceScope) destroyOne(destroyable IApiDestroyable, err error) {
+	defer func() {
+		panicErr := recover()
+		if panicErr != nil {
+			logger.Error("%s destroy %T failed: %v", this.ctx.GetRequestId(), destroyable, panicErr)
+		}
+	}()
+	destroyable.Destroy(err)
+}
+
+/**
+ * 通过注册的方式指定某类Service的作用域（request、singleton、transient），Field上的scope标签优先于注册值。
+ * 作用域在注册路由时写入注入计划，因此需要在StartListen之前调用。
+ * service可以传入Service结构体实例或结构体指针，例如：SetServiceScope((*OrderService)(nil), SERVICE_SCOPE_SINGLETON)
+ */
+func (this *ApiServer) SetServiceScope(service interface{}, scope string) {
+	if !isLegalServiceScope(scope) {
+		panic(fmt.Sprintf("illegal service scope <%s>", scope))
+	}
+	serviceType := reflect.TypeOf(service)
+	if serviceType.Kind() == reflect.Ptr {
+		serviceType = serviceType.Elem()
+	}
+	if this.serviceScopes == nil {
+		this.serviceScopes = make(map[reflect.Type]string)
+	}
+	this.serviceScopes[serviceType] = scope
+}
+
+/**
+ * 判断作用域名称是否合法
+ */
+func isLegalServiceScope(scope string) bool {
+	return scope == SERVICE_SCOPE_REQUEST || scope == SERVICE_SCOPE_SINGLETON || scope == SERVICE_SCOPE_TRANSIENT
+}
+
+/**
+ * 确定一个Service Field的作用域：Field标签 > 注册值 > 默认的request作用域
+ */
+func (this *ApiServer) getServiceScope(serviceType reflect.Type, fieldTag reflect.StructTag) string {
+	tagScope := fieldTag.Get(SERVICE_SCOPE_TAG)
+	if tagScope != "" {
+		if isLegalServiceScope(tagScope) {
+			return tagScope
+		}
+		logger.Warn("illegal service scope tag <%s> on %s, use default scope", tagScope, serviceType.String())
+	}
+	scope, ok := this.serviceScopes[serviceType]
+	if ok {
+		return scope
+	}
+	return SERVICE_SCOPE_REQUEST
+}
+
+/**
+ * 按作用域获取一个Service实例：request作用域在同一请求内共享，singleton在整个ApiServer内共享，transient每次都新建。
+ * Field未指定数据源时使用引用方的数据源inheritDataSource
+ */
+func (this *ApiServer) resolveService(fieldPlan *serviceFieldPlan, inheritDataSource string, scope *serviceScope) reflect.Value {
+	servicePlan := fieldPlan.service
+	dataSource := fieldPlan.dataSource
+	if dataSource == "" {
+		dataSource = inheritDataSource
+	}
+	switch fieldPlan.scope {
+	case SERVICE_SCOPE_SINGLETON:
+		return this.resolveSingletonService(servicePlan, dataSource)
+	case SERVICE_SCOPE_TRANSIENT:
+		return this.newService(servicePlan, dataSource, scope, false)
+	default:
+		serviceVal, ok := scope.services[serviceKey{servicePlan.serviceType, dataSource}]
+		if ok {
+			return serviceVal
+		}
+		return this.newService(servicePlan, dataSource, scope, true)
+	}
+}
+
+/**
+ * 获取单例Service。单例Service不绑定任何请求上下文，其依赖的request作用域Service归属于单例自身的实例容器。
+ * 每个单例只构造一次，构造过程可能递归获取其它单例，因此只在获取单例记录时持锁（注册时已校验不存在循环依赖）
+ */
+func (this *ApiServer) resolveSingletonService(servicePlan *serviceInjectPlan, dataSource string) reflect.Value {
+	key := serviceKey{servicePlan.serviceType, dataSource}
+	this.singletonLock.Lock()
+	if this.singletonServices == nil {
+		this.singletonServices = make(map[serviceKey]*singletonService)
+	}
+	singleton, ok := this.singletonServices[key]
+	if !ok {
+		singleton = new(singletonService)
+		this.singletonServices[key] = singleton
+	}
+	this.singletonLock.Unlock()
+	singleton.once.Do(func() {
+		singleton.serviceVal = this.newService(servicePlan, dataSource, newSingletonScope(), false)
+	})
+	return singleton.serviceVal
+}
+
+/**
+ * 按注入计划实例化一个使用指定数据源的Service并组装其DB操作对象及引用的其它Service，shared为true时将实例放入请求容器共享
+ */
+func (this *ApiServer) newService(servicePlan *serviceInjectPlan, dataSource string, scope *serviceScope, shared bool) reflect.Value {
+	serviceVal := reflect.New(servicePlan.serviceType)
+	serviceObj := serviceVal.Interface().(IApiService)
+	//先放入容器再组装依赖，使依赖链中再次引用本类型时拿到的是同一个实例
+	if shared {
+		scope.services[serviceKey{servicePlan.serviceType, dataSource}] = serviceVal
+	}
+	if scope.ctx != nil {
+		serviceObj.setContext(scope.ctx)
+	}
+	if scope.singleton {
+		serviceObj.setSingleton()
+	}
+	//Service持有所选数据源的普通连接，事务连接在使用时通过本Service或请求上下文的事务栈获取
+	serviceObj.setDataSource(dataSource, this.GetDataSource)
+	serviceObj.SetOrmConn(this.GetDataSource(dataSource))
+	//注入Service中声明的组件及配置
+	assembleFixedFields(serviceVal.Elem(), servicePlan.fixedFields)
+	//组装Service中的DB操作对象
+	serviceVal = this.assembleDbToService(serviceObj, serviceVal, servicePlan, scope)
+	//组织Service间的横向引用
+	serviceVal = this.assembleServiceToService(serviceObj, serviceVal, servicePlan, scope)
+	serviceObj.Init()
+	scope.track(serviceObj)
+	return serviceVal
+}
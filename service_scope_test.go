@@ -0,0 +1,158 @@
+package simpleapi
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+type scopeTestStockService struct {
+	BaseService
+}
+
+type scopeTestOrderService struct {
+	Stock *scopeTestStockService
+	BaseService
+}
+
+type scopeTestHandler struct {
+	Order     *scopeTestOrderService
+	Stock     *scopeTestStockService
+	NewStock  *scopeTestStockService `scope:"transient"`
+	SomeStock *scopeTestStockService `scope:"singleton"`
+	BaseHandler
+}
+
+func (this *scopeTestHandler) HandleRequest(r *Request) (interface{}, error) {
+	return nil, nil
+}
+
+func TestRequestScopedService(t *testing.T) {
+	s := new(ApiServer)
+	s.Init()
+	ctx := new(RequestContext)
+	ctx.Init()
+	plan, err := s.buildHandlerInjectPlan(reflect.TypeOf(scopeTestHandler{}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	handlerVal := s.assembleServiceToHandler(reflect.New(plan.handlerType), plan, newServiceScope(ctx))
+	handler := handlerVal.Interface().(*scopeTestHandler)
+	if handler.Order.Stock != handler.Stock {
+		t.Fatal("request scoped service should be shared in one request")
+	}
+	if handler.NewStock == handler.Stock || handler.NewStock == handler.SomeStock {
+		t.Fatal("transient service should not be shared")
+	}
+	otherHandlerVal := s.assembleServiceToHandler(reflect.New(plan.handlerType), plan, newServiceScope(ctx))
+	otherHandler := otherHandlerVal.Interface().(*scopeTestHandler)
+	if otherHandler.Stock == handler.Stock {
+		t.Fatal("request scoped service should not be shared across requests")
+	}
+	if otherHandler.SomeStock != handler.SomeStock {
+		t.Fatal("singleton service should be shared across requests")
+	}
+}
+
+var singletonTestInitCount int32
+
+type singletonTestService struct {
+	Stock *scopeTestStockService
+	BaseService
+}
+
+func (this *singletonTestService) Init() {
+	atomic.AddInt32(&singletonTestInitCount, 1)
+}
+
+type singletonTestHandler struct {
+	Shared *singletonTestService `scope:"singleton"`
+	BaseHandler
+}
+
+func (this *singletonTestHandler) HandleRequest(r *Request) (interface{}, error) {
+	return nil, nil
+}
+
+func TestSingletonService(t *testing.T) {
+	s := new(ApiServer)
+	s.Init()
+	plan, err := s.buildHandlerInjectPlan(reflect.TypeOf(singletonTestHandler{}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	handlers := make([]*singletonTestHandler, 20)
+	wg := new(sync.WaitGroup)
+	for i := range handlers {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			ctx := new(RequestContext)
+			ctx.Init()
+			handlers[i] = s.assembleServiceToHandler(reflect.New(plan.handlerType), plan, newServiceScope(ctx)).Interface().(*singletonTestHandler)
+		}(i)
+	}
+	wg.Wait()
+	if atomic.LoadInt32(&singletonTestInitCount) != 1 {
+		t.Fatalf("singleton service should be constructed once, got %d", singletonTestInitCount)
+	}
+	for _, handler := range handlers {
+		if handler.Shared != handlers[0].Shared {
+			t.Fatal("singleton service should be shared across requests")
+		}
+	}
+	if handlers[0].Shared.BeginTransaction() == nil || handlers[0].Shared.Stock.BeginTransaction() == nil {
+		t.Fatal("singleton service and its dependencies should not open transactions")
+	}
+}
+
+var destroyTestOrder []string
+
+type destroyTestDb struct {
+	BaseDbOperator
+}
+
+func (this *destroyTestDb) Destroy(err error) {
+	destroyTestOrder = append(destroyTestOrder, "db")
+}
+
+type destroyTestService struct {
+	DB *destroyTestDb
+	BaseService
+}
+
+func (this *destroyTestService) Destroy(err error) {
+	destroyTestOrder = append(destroyTestOrder, "service")
+	panic("destroy panic should be isolated")
+}
+
+type destroyTestHandler struct {
+	Service *destroyTestService
+	BaseHandler
+}
+
+func (this *destroyTestHandler) HandleRequest(r *Request) (interface{}, error) {
+	return nil, errors.New("handle failed")
+}
+
+func (this *destroyTestHandler) Destroy(err error) {
+	destroyTestOrder = append(destroyTestOrder, "handler:"+err.Error())
+}
+
+func TestDestroyInReverseOrder(t *testing.T) {
+	s := new(ApiServer)
+	s.Init()
+	s.GetTokenFunnel().SetDefaultTokenQuota(10)
+	s.RegisterHandler(http.MethodGet, "/destroy", destroyTestHandler{})
+	s.registerStructHandlerRoute()
+	s.GetTokenFunnel().fullTokenPerSec()
+	s.httpRouter.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/destroy", nil))
+	if strings.Join(destroyTestOrder, ",") != "handler:handle failed,service,db" {
+		t.Fatalf("unexpected destroy order: %v", destroyTestOrder)
+	}
+}
@@ -1,9 +1,11 @@
 package simpleapi
 
 import (
+	"errors"
 	"fmt"
 	"github.com/duhaifeng/simpleapi/db"
 	"gorm.io/gorm"
+	"time"
 )
 
 /**
@@ -50,6 +52,14 @@ type IApiHandler interface {
 	HandleRequest(r *Request) (interface{}, error)
 }
 
+/**
+ * 定义可选的销毁方法结构：Handler、Service、DB操作对象实现该接口后，会在响应写回客户端之后按构造的逆序被调用，
+ * err为Handler返回的错误（包括未处理的panic），可用于清理临时文件、单独打开的连接以及未提交的事务
+ */
+type IApiDestroyable interface {
+	Destroy(err error)
+}
+
 /**
  * Handler层基类定义
  */
@@ -127,8 +137,12 @@ func (this *Interceptor) HandleRequest(r *Request) (interface{}, error) {
 type IApiService interface {
 	Init()
 	setContext(*RequestContext)
+	setDataSource(string, func(string) *db.GormProxy)
+	setSingleton()
 	SetOrmConn(*db.GormProxy)
 	GetOrmConn() *db.GormProxy
+	GetDataSourceName() string
+	GetDataSourceConn(string) *db.GormProxy
 	GetContext() *RequestContext
 	OpenOrmConnSeparately(host, port, user, pass, database string) error
 }
@@ -138,8 +152,11 @@ type IApiService interface {
  */
 type BaseService struct {
 	//Service层需要实现事务管理，因此DB连接托管在本层
-	ormConn   *db.GormProxy
-	ormTxConn *db.GormProxy
+	ormConn      *db.GormProxy
+	dataSource   string                     //本Service使用的数据源名称
+	dataSourceOf func(string) *db.GormProxy //按名称获取服务器注册的其它数据源
+	txStack      []*serviceTx               //本Service开启或加入的事务，栈顶为最内层
+	singleton    bool                       //单例Service被所有请求共享，构造后不再修改任何状态，因此不能开启事务
 	BaseDefine
 }
 
@@ -160,29 +177,90 @@ func (this *BaseService) SetOrmConn(ormConn *db.GormProxy) {
 }
 
 /**
- * 获取Gorm数据库连接对象
+ * 将Service标记为单例（及单例依赖的Service），由框架在构造Service时调用
+ */
+func (this *BaseService) setSingleton() {
+	this.singleton = true
+}
+
+/**
+ * 设置本Service使用的数据源，由框架在构造Service时调用
+ */
+func (this *BaseService) setDataSource(dataSource string, dataSourceOf func(string) *db.GormProxy) {
+	this.dataSource = dataSource
+	this.dataSourceOf = dataSourceOf
+}
+
+/**
+ * 获取本Service使用的数据源名称
+ */
+func (this *BaseService) GetDataSourceName() string {
+	if this.dataSource == "" {
+		return DEFAULT_DATA_SOURCE
+	}
+	return this.dataSource
+}
+
+/**
+ * 获取本Service所用数据源的Gorm数据库连接对象
  */
 func (this *BaseService) GetOrmConn() *db.GormProxy {
-	//logger.Debug("%s get orm transaction %p %v", this.GetContext().GetRequestId(), this, this.ormTxConn)
-	if this.ormTxConn != nil {
-		return this.ormTxConn
+	return this.GetDataSourceConn(this.GetDataSourceName())
+}
+
+/**
+ * 获取指定数据源的Gorm数据库连接对象：优先使用本Service在该数据源上开启的事务，其次加入调用方在本次请求中开启的事务，最后使用普通连接
+ */
+func (this *BaseService) GetDataSourceConn(dataSource string) *db.GormProxy {
+	for i := len(this.txStack) - 1; i >= 0; i-- {
+		if this.txStack[i].dataSource == dataSource {
+			return this.txStack[i].conn
+		}
+	}
+	if this.ctx != nil {
+		tx := this.ctx.currentTx(dataSource)
+		if tx != nil {
+			return tx
+		}
 	}
-	return this.ormConn
+	if dataSource == this.GetDataSourceName() || this.dataSourceOf == nil {
+		return this.ormConn
+	}
+	return this.dataSourceOf(dataSource)
 }
 
 /**
- * 打开数据库事务
+ * 按REQUIRED传播方式打开数据库事务：调用方已经开启事务时加入该事务，否则开启新事务
  */
 func (this *BaseService) BeginTransaction() error {
-	//if this.ormTxConn != nil {
-	//	return errors.New("db transaction have already been opened")
-	//}
-	var err error
-	this.ormTxConn, err = this.ormConn.Begin()
+	return this.BeginTransactionWith(TX_PROPAGATION_REQUIRED)
+}
+
+/**
+ * 按指定的传播方式打开数据库事务，传播方式见TX_PROPAGATION_*定义
+ */
+func (this *BaseService) BeginTransactionWith(propagation string) error {
+	return this.BeginTransactionOn(this.GetDataSourceName(), propagation)
+}
+
+/**
+ * 在指定数据源上按指定的传播方式打开数据库事务，每个数据源的事务独立传播
+ */
+func (this *BaseService) BeginTransactionOn(dataSource, propagation string) error {
+	if this.singleton {
+		//事务栈属于一次请求，单例被并发的请求共享，开启事务会使请求之间相互加入对方的事务
+		return errors.New("singleton service can not open transactions, open them in a request scoped service")
+	}
+	serviceTx, err := beginServiceTx(this.GetDataSourceConn(dataSource), propagation)
 	if err != nil {
 		return err
 	}
-	logger.Debug("%s orm transaction opened %p %v", this.GetContext().GetRequestId(), this, this.ormTxConn)
+	serviceTx.dataSource = dataSource
+	this.txStack = append(this.txStack, serviceTx)
+	if serviceTx.owned && this.ctx != nil {
+		this.ctx.pushTx(dataSource, serviceTx.conn)
+	}
+	logger.Debug("%s orm transaction opened %p %s %s %v", this.GetContext().GetRequestId(), this, dataSource, propagation, serviceTx.conn)
 	return nil
 }
 
@@ -190,16 +268,151 @@ func (this *BaseService) BeginTransaction() error {
  * 提交数据库事务
  */
 func (this *BaseService) CommitTransaction() error {
-	logger.Debug("%s commit orm transaction %p %v", this.GetContext().GetRequestId(), this, this.ormTxConn)
-	return this.ormTxConn.Commit()
+	serviceTx := this.popServiceTx()
+	if serviceTx == nil {
+		return errors.New("commit transaction failed. transaction is not opened")
+	}
+	logger.Debug("%s commit orm transaction %p %s %v", this.GetContext().GetRequestId(), this, serviceTx.propagation, serviceTx.conn)
+	return serviceTx.commit()
 }
 
 /**
  * 回滚数据库事务
  */
 func (this *BaseService) RollbackTransaction() error {
-	logger.Debug("%s rollback orm transaction %p %v", this.GetContext().GetRequestId(), this, this.ormTxConn)
-	return this.ormTxConn.Rollback()
+	serviceTx := this.popServiceTx()
+	if serviceTx == nil {
+		return errors.New("rollback transaction failed. transaction is not opened")
+	}
+	logger.Debug("%s rollback orm transaction %p %s %v", this.GetContext().GetRequestId(), this, serviceTx.propagation, serviceTx.conn)
+	return serviceTx.rollback()
+}
+
+/**
+ * 移除本Service最内层的事务，事务结束后恢复使用外层事务或普通连接
+ */
+func (this *BaseService) popServiceTx() *serviceTx {
+	if len(this.txStack) == 0 {
+		return nil
+	}
+	serviceTx := this.txStack[len(this.txStack)-1]
+	this.txStack = this.txStack[:len(this.txStack)-1]
+	if serviceTx.owned && this.ctx != nil {
+		this.ctx.popTx(serviceTx.conn)
+	}
+	return serviceTx
+}
+
+/**
+ * 按REQUIRED传播方式在事务中执行fn：fn返回nil时提交事务，返回错误或panic时回滚事务（panic会在回滚后继续抛出）
+ */
+func (this *BaseService) InTransaction(fn func() error) error {
+	return this.InTransactionWith(TX_PROPAGATION_REQUIRED, fn)
+}
+
+/**
+ * 按指定的传播方式在事务中执行fn
+ */
+func (this *BaseService) InTransactionWith(propagation string, fn func() error) error {
+	return this.InTransactionOn(this.GetDataSourceName(), propagation, fn)
+}
+
+/**
+ * 在指定数据源上按指定的传播方式在事务中执行fn
+ */
+func (this *BaseService) InTransactionOn(dataSource, propagation string, fn func() error) error {
+	err := this.BeginTransactionOn(dataSource, propagation)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		panicErr := recover()
+		if panicErr != nil {
+			this.rollbackQuietly()
+			panic(panicErr)
+		}
+	}()
+	err = fn()
+	if err != nil {
+		this.rollbackQuietly()
+		return err
+	}
+	return this.CommitTransaction()
+}
+
+/**
+ * 注册当前事务提交成功后执行的回调，回调以本次请求的上下文为参数，用于在数据落库后发送通知、清理缓存等。
+ * 加入外层事务或通过保存点嵌套时，回调在最外层事务提交后执行；当前不在事务中时立即执行
+ */
+func (this *BaseService) OnCommit(callback func(ctx *RequestContext)) {
+	conn := this.GetOrmConn()
+	if conn == nil {
+		this.runTxCallback("commit", callback)
+		return
+	}
+	conn.OnCommit(func() {
+		this.runTxCallback("commit", callback)
+	})
+}
+
+/**
+ * 注册当前事务回滚后执行的回调，当前不在事务中时回调会被忽略
+ */
+func (this *BaseService) OnRollback(callback func(ctx *RequestContext)) {
+	conn := this.GetOrmConn()
+	if conn == nil {
+		return
+	}
+	conn.OnRollback(func() {
+		this.runTxCallback("rollback", callback)
+	})
+}
+
+/**
+ * 执行事务回调，回调中的panic会被捕获并记录日志，不会影响事务结果及其它回调
+ */
+func (this *BaseService) runTxCallback(event string, callback func(ctx *RequestContext)) {
+	defer func() {
+		err := recover()
+		if err != nil {
+			logger.Error("%s orm transaction %s callback panic: %v", this.GetContext().GetRequestId(), event, err)
+		}
+	}()
+	callback(this.GetContext())
+}
+
+/**
+ * 按默认重试策略在事务中执行fn，遇到死锁、锁等待超时等可重试的错误时重新执行整个fn
+ */
+func (this *BaseService) InRetryTransaction(fn func() error) error {
+	return this.InRetryTransactionWith(db.DefaultTxRetryPolicy, fn)
+}
+
+/**
+ * 按指定的重试策略在事务中执行fn。加入调用方已开启的事务时不会重试，错误直接返回给开启事务的一方处理
+ */
+func (this *BaseService) InRetryTransactionWith(policy db.TxRetryPolicy, fn func() error) error {
+	conn := this.GetOrmConn()
+	joined := conn != nil && conn.IsInTx()
+	for attempt := 1; ; attempt++ {
+		err := this.InTransaction(fn)
+		if err == nil || joined || !db.IsRetryableTxError(err) || attempt >= policy.MaxAttempts {
+			return err
+		}
+		backoff := policy.Backoff(attempt)
+		logger.Warn("%s orm transaction attempt %d/%d failed, retry after %s: %s", this.GetContext().GetRequestId(), attempt, policy.MaxAttempts, backoff, err.Error())
+		time.Sleep(backoff)
+	}
+}
+
+/**
+ * 回滚事务，回滚失败只记录日志，用于已经有错误需要返回的场景
+ */
+func (this *BaseService) rollbackQuietly() {
+	err := this.RollbackTransaction()
+	if err != nil {
+		logger.Error("%s rollback orm transaction failed: %s", this.GetContext().GetRequestId(), err.Error())
+	}
 }
 
 /**
@@ -208,6 +421,7 @@ func (this *BaseService) RollbackTransaction() error {
 type IApiDbOperator interface {
 	Init()
 	setContext(*RequestContext)
+	setDataSource(string)
 	SetService(*IApiService)
 	GetContext() *RequestContext
 	OpenOrmConnSeparately(host, port, user, pass, database string) error
@@ -218,10 +432,18 @@ type IApiDbOperator interface {
  */
 type BaseDbOperator struct {
 	separateOrmConn *db.GormProxy //一个单独的数据库连接，用于直接测试DbOperator的场景
+	dataSource      string        //单独指定的数据源，为空时使用所属Service的数据源
 	service         *IApiService
 	BaseDefine
 }
 
+/**
+ * 设置DB操作对象单独使用的数据源，由框架在构造DB操作对象时调用
+ */
+func (this *BaseDbOperator) setDataSource(dataSource string) {
+	this.dataSource = dataSource
+}
+
 /**
  * 将Service对象反向引用给DB对象，用于DB对象获取数据库连接等信息
  */
@@ -238,13 +460,61 @@ func (this *BaseDbOperator) OpenOrmConnSeparately(host, port, user, pass, databa
 }
 
 /**
- * 获取操作数据库的ORM连接
+ * 获取操作数据库的ORM连接，连接携带请求ID，执行的SQL日志以请求ID作为前缀
  */
 func (this *BaseDbOperator) OrmConn() *gorm.DB {
+	return this.withSqlContext(this.ormProxy().Conn)
+}
+
+/**
+ * 获取DB操作对象使用的数据库连接：测试用的单独连接、单独指定的数据源或所属Service的连接（及其事务）
+ */
+func (this *BaseDbOperator) ormProxy() *db.GormProxy {
 	//如果打开了测试用的DB连接，则优先使用
 	if this.separateOrmConn != nil {
-		return this.separateOrmConn.Conn
-	} else {
-		return (*this.service).GetOrmConn().Conn
+		return this.separateOrmConn
+	}
+	if this.dataSource != "" {
+		return (*this.service).GetDataSourceConn(this.dataSource)
+	}
+	return (*this.service).GetOrmConn()
+}
+
+/**
+ * 获取执行原生SQL的连接，与OrmConn共用同一个数据源及事务，用于不适合通过ORM表达的查询
+ */
+func (this *BaseDbOperator) SqlConn() (*db.MySqlProxy, error) {
+	conn := this.ormProxy()
+	if conn == nil {
+		return nil, errors.New("db operator has no orm conn")
 	}
+	return conn.SqlProxy()
+}
+
+/**
+ * 获取执行读操作的ORM连接：事务外的读操作会被路由到数据源的从库，请求上下文设置了强制主库时使用主库
+ */
+func (this *BaseDbOperator) ReadOrmConn() *gorm.DB {
+	conn := this.ormProxy()
+	if this.ctx != nil && this.ctx.IsForcePrimary() {
+		return this.withSqlContext(conn.Conn)
+	}
+	return this.withSqlContext(conn.ReadConn())
+}
+
+/**
+ * 为连接附加请求上下文中的请求ID及SQL日志开关
+ */
+func (this *BaseDbOperator) withSqlContext(conn *gorm.DB) *gorm.DB {
+	if this.ctx == nil || conn == nil {
+		return conn
+	}
+	return conn.WithContext(this.ctx.SqlContext())
+}
+
+/**
+ * 获取应用了过滤条件的读查询，model为查询的模型（结构体指针），过滤值按模型字段的类型转换
+ */
+func (this *BaseDbOperator) FilteredQuery(model interface{}, filters []*db.Filter) (*gorm.DB, error) {
+	return db.ApplyFilters(this.ReadOrmConn(), model, filters)
 }
@@ -1,7 +1,9 @@
 package simpleapi
 
 import (
+	"sort"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -12,17 +14,40 @@ type TokenFunnel struct {
 	defaultQuota           int
 	tokenChanBucket        map[string]chan byte
 	tokenQuotaBucket       map[string]int
+	tokenCounterBucket     map[string]*tokenCounter
 	undefinedTokenLogCount map[string]int
+	maxWait                time.Duration //路由请求等待令牌的最长时间，为0时一直等待
 	lock                   sync.Mutex
 }
 
+/**
+ * 单个令牌的访问计数器（计数字段通过atomic操作，避免在令牌等待期间持有漏斗锁）
+ */
+type tokenCounter struct {
+	acquiredCount  int64
+	waitingCount   int64
+	totalWaitCount int64
+	rejectedCount  int64
+}
+
+/**
+ * 令牌漏斗运行时状态快照，用于对外展示配额及访问情况
+ */
+type TokenStat struct {
+	TokenName      string `json:"token_name"`
+	Quota          int    `json:"quota"`
+	CurrentTokens  int    `json:"current_tokens"`
+	AcquiredCount  int64  `json:"acquired_count"`
+	WaitingCount   int64  `json:"waiting_count"`
+	TotalWaitCount int64  `json:"total_wait_count"`
+	RejectedCount  int64  `json:"rejected_count"`
+}
+
 /**
  * 初始化访问令牌漏斗
  */
 func (this *TokenFunnel) Init() {
-	this.tokenChanBucket = make(map[string]chan byte)
-	this.tokenQuotaBucket = make(map[string]int)
-	this.undefinedTokenLogCount = make(map[string]int)
+	this.initBuckets()
 	//启动每秒投放令牌的后台worker协程
 	go func() {
 		for {
@@ -32,6 +57,16 @@ func (this *TokenFunnel) Init() {
 	}()
 }
 
+/**
+ * 初始化令牌管道、配额及计数器等存储
+ */
+func (this *TokenFunnel) initBuckets() {
+	this.tokenChanBucket = make(map[string]chan byte)
+	this.tokenQuotaBucket = make(map[string]int)
+	this.tokenCounterBucket = make(map[string]*tokenCounter)
+	this.undefinedTokenLogCount = make(map[string]int)
+}
+
 /**
  * 向每个令牌管道投放令牌的Worker
  */
@@ -39,7 +74,7 @@ func (this *TokenFunnel) fullTokenPerSec() {
 	this.lock.Lock()
 	defer this.lock.Unlock()
 	for tokenName, tokenChan := range this.tokenChanBucket {
-		tokenQuota := this.GetTokenQuota(tokenName)
+		tokenQuota := this.getTokenQuota(tokenName)
 		//如果配额没有定义，则不发放配额
 		if tokenQuota == 0 {
 			logger.Warn("there is no token quota for <%s>", tokenName)
@@ -62,11 +97,22 @@ func (this *TokenFunnel) SetDefaultTokenQuota(defaultQuotaPerSec int) {
 	this.defaultQuota = defaultQuotaPerSec
 }
 
+/**
+ * 获取默认配额
+ */
+func (this *TokenFunnel) GetDefaultTokenQuota() int {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	return this.defaultQuota
+}
+
 /**
  * 如果指定名字的token没有设置配额，则将其注册为使用默认配额，以确保配额生效
  */
 func (this *TokenFunnel) AutocompleteTokenQuota(tokenName string) {
+	this.lock.Lock()
 	_, ok := this.tokenQuotaBucket[tokenName]
+	this.lock.Unlock()
 	if ok {
 		return
 	}
@@ -74,7 +120,7 @@ func (this *TokenFunnel) AutocompleteTokenQuota(tokenName string) {
 }
 
 /**
- * 设定指定token的配额
+ * 设定指定token的配额。运行期间修改已存在token的配额时，沿用原有的令牌管道，避免正在等待令牌的请求被永久阻塞
  */
 func (this *TokenFunnel) SetTokenQuota(tokenName string, tokenQuotaPerSec int) {
 	this.lock.Lock()
@@ -86,14 +132,40 @@ func (this *TokenFunnel) SetTokenQuota(tokenName string, tokenQuotaPerSec int) {
 	if tokenQuotaPerSec == 0 {
 		tokenQuotaPerSec = this.defaultQuota
 	}
+	oldQuota, exists := this.tokenQuotaBucket[tokenName]
 	this.tokenQuotaBucket[tokenName] = tokenQuotaPerSec
-	this.tokenChanBucket[tokenName] = make(chan byte, 100000)
+	if !exists {
+		this.tokenChanBucket[tokenName] = make(chan byte, 100000)
+		this.tokenCounterBucket[tokenName] = new(tokenCounter)
+		return
+	}
+	if oldQuota == tokenQuotaPerSec {
+		return
+	}
+	//配额调小时，将管道中超出新配额的令牌收回，使新配额立即生效
+	tokenChan := this.tokenChanBucket[tokenName]
+	for len(tokenChan) > tokenQuotaPerSec {
+		select {
+		case <-tokenChan:
+		default:
+		}
+	}
+	logger.Info("token quota of <%s> changed from %d to %d", tokenName, oldQuota, tokenQuotaPerSec)
 }
 
 /**
  * 获取指定token的配额，如果配额设定为0则使用默认配额
  */
 func (this *TokenFunnel) GetTokenQuota(tokenName string) int {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	return this.getTokenQuota(tokenName)
+}
+
+/**
+ * 获取指定token的配额（调用方需持有锁）
+ */
+func (this *TokenFunnel) getTokenQuota(tokenName string) int {
 	tokenQuota, ok := this.tokenQuotaBucket[tokenName]
 	//如果没有定义令牌配额，则使用默认配额
 	if ok {
@@ -102,25 +174,145 @@ func (this *TokenFunnel) GetTokenQuota(tokenName string) int {
 	return this.defaultQuota
 }
 
+/**
+ * 设置路由请求等待令牌的最长时间，超时的请求不再等待并返回429，为0时一直等待到令牌发放为止
+ */
+func (this *TokenFunnel) SetMaxWait(maxWait time.Duration) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	this.maxWait = maxWait
+}
+
+/**
+ * 获取路由请求等待令牌的最长时间
+ */
+func (this *TokenFunnel) GetMaxWait() time.Duration {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	return this.maxWait
+}
+
 /**
  * 获取指定名称的令牌，如果当前1秒内的令牌用完，则会阻塞到下一秒发放令牌为止。
  * 对于未注册的令牌，不进行配额限制，直接放行。
  * 对于配额设定为0的令牌，使用默认配额。
  */
 func (this *TokenFunnel) GetToken(tokenName string, ctx *RequestContext) {
+	this.GetTokenWithTimeout(tokenName, ctx, 0)
+}
+
+/**
+ * 获取指定名称的令牌，令牌用完时最多等待timeout，等待超时返回false并记为一次拒绝，timeout为0时一直等待。
+ * 对于未注册的令牌，不进行配额限制，直接放行。
+ */
+func (this *TokenFunnel) GetTokenWithTimeout(tokenName string, ctx *RequestContext, timeout time.Duration) bool {
+	this.lock.Lock()
 	tokenChan, ok := this.tokenChanBucket[tokenName]
+	counter := this.tokenCounterBucket[tokenName]
+	this.lock.Unlock()
 	if !ok {
 		this.logUndefinedTokenName(tokenName, ctx)
-		return
+		return true
 	}
-	if len(tokenChan) == 0 {
-		if ctx != nil {
-			logger.Debug("%s wait access token for <%s> ", ctx.GetRequestId(), tokenName)
-		} else {
-			logger.Debug("wait access token for <%s> ", tokenName)
-		}
+	select {
+	case <-tokenChan:
+		atomic.AddInt64(&counter.acquiredCount, 1)
+		return true
+	default:
+	}
+	if ctx != nil {
+		logger.Debug("%s wait access token for <%s> ", ctx.GetRequestId(), tokenName)
+	} else {
+		logger.Debug("wait access token for <%s> ", tokenName)
+	}
+	atomic.AddInt64(&counter.totalWaitCount, 1)
+	atomic.AddInt64(&counter.waitingCount, 1)
+	defer atomic.AddInt64(&counter.waitingCount, -1)
+	if timeout <= 0 {
+		<-tokenChan
+		atomic.AddInt64(&counter.acquiredCount, 1)
+		return true
+	}
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case <-tokenChan:
+		atomic.AddInt64(&counter.acquiredCount, 1)
+		return true
+	case <-timer.C:
+		atomic.AddInt64(&counter.rejectedCount, 1)
+		return false
+	}
+}
+
+/**
+ * 尝试获取指定名称的令牌，当前1秒内的令牌用完时不阻塞，直接返回false并记为一次拒绝。
+ * 对于未注册的令牌，不进行配额限制，直接放行。
+ */
+func (this *TokenFunnel) TryGetToken(tokenName string, ctx *RequestContext) bool {
+	this.lock.Lock()
+	tokenChan, ok := this.tokenChanBucket[tokenName]
+	counter := this.tokenCounterBucket[tokenName]
+	this.lock.Unlock()
+	if !ok {
+		this.logUndefinedTokenName(tokenName, ctx)
+		return true
+	}
+	select {
+	case <-tokenChan:
+		atomic.AddInt64(&counter.acquiredCount, 1)
+		return true
+	default:
+		atomic.AddInt64(&counter.rejectedCount, 1)
+		return false
+	}
+}
+
+/**
+ * 获取指定token的运行时状态
+ */
+func (this *TokenFunnel) GetTokenStat(tokenName string) (*TokenStat, bool) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	_, ok := this.tokenChanBucket[tokenName]
+	if !ok {
+		return nil, false
+	}
+	return this.buildTokenStat(tokenName), true
+}
+
+/**
+ * 获取所有已注册token的运行时状态，按token名称排序
+ */
+func (this *TokenFunnel) ListTokenStats() []*TokenStat {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	tokenNames := make([]string, 0, len(this.tokenChanBucket))
+	for tokenName := range this.tokenChanBucket {
+		tokenNames = append(tokenNames, tokenName)
+	}
+	sort.Strings(tokenNames)
+	stats := make([]*TokenStat, 0, len(tokenNames))
+	for _, tokenName := range tokenNames {
+		stats = append(stats, this.buildTokenStat(tokenName))
+	}
+	return stats
+}
+
+/**
+ * 构造指定token的状态快照（调用方需持有锁）
+ */
+func (this *TokenFunnel) buildTokenStat(tokenName string) *TokenStat {
+	counter := this.tokenCounterBucket[tokenName]
+	return &TokenStat{
+		TokenName:      tokenName,
+		Quota:          this.getTokenQuota(tokenName),
+		CurrentTokens:  len(this.tokenChanBucket[tokenName]),
+		AcquiredCount:  atomic.LoadInt64(&counter.acquiredCount),
+		WaitingCount:   atomic.LoadInt64(&counter.waitingCount),
+		TotalWaitCount: atomic.LoadInt64(&counter.totalWaitCount),
+		RejectedCount:  atomic.LoadInt64(&counter.rejectedCount),
 	}
-	<-tokenChan
 }
 
 /**
@@ -0,0 +1,102 @@
+package simpleapi
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+/**
+ * 修改令牌配额的请求体定义
+ */
+type TokenQuotaUpdate struct {
+	TokenName string `json:"token_name"`
+	Quota     int    `json:"quota"`
+}
+
+/**
+ * 令牌漏斗管理接口的Handler，由框架直接构造（而非反射生成），以便持有ApiServer的令牌漏斗
+ */
+type tokenFunnelAdminHandler struct {
+	funnel *TokenFunnel
+	action func(funnel *TokenFunnel, ctx *RequestContext, r *Request) (interface{}, error)
+	BaseHandler
+}
+
+/**
+ * 执行具体的管理动作
+ */
+func (this *tokenFunnelAdminHandler) HandleRequest(r *Request) (interface{}, error) {
+	return this.action(this.funnel, this.GetContext(), r)
+}
+
+/**
+ * 注册令牌漏斗的HTTP管理接口：
+ * GET  {pathPrefix}/token_quotas 查询所有路由的配额、当前令牌数、等待数及拒绝数
+ * POST {pathPrefix}/token_quota  修改指定路由的配额，Body格式为{"token_name":"/xxx","quota":10}
+ * guard为保护管理接口的拦截器（例如鉴权拦截器），会排在全局拦截器之后执行，传入nil则只经过全局拦截器
+ */
+func (this *ApiServer) RegisterTokenFunnelAdmin(pathPrefix string, guard IApiHandler) {
+	this.HandRequest(http.MethodGet, pathPrefix+"/token_quotas", this.tokenFunnelAdminFunc(guard, listTokenQuota))
+	this.HandRequest(http.MethodPost, pathPrefix+"/token_quota", this.tokenFunnelAdminFunc(guard, updateTokenQuota))
+}
+
+/**
+ * 将管理动作包装为函数句柄，请求会先经过拦截器链再到达管理动作
+ */
+func (this *ApiServer) tokenFunnelAdminFunc(guard IApiHandler, action func(*TokenFunnel, *RequestContext, *Request) (interface{}, error)) ApiHandlerFunc {
+	return func(r *Request, w *Response) {
+		ctx := r.GetContext()
+		handler := &tokenFunnelAdminHandler{funnel: this.GetTokenFunnel(), action: action}
+		handler.setContext(ctx)
+		handler.setReqAndResp(r, w)
+		handler.Init()
+		var headerInterceptor IApiHandler
+		if guard != nil {
+			headerInterceptor = this.assembleInterceptors(handler, ctx, r, w, guard)
+		} else {
+			headerInterceptor = this.assembleInterceptors(handler, ctx, r, w)
+		}
+		this.callStructHandler(headerInterceptor, ctx, r, w)
+	}
+}
+
+/**
+ * 查询所有令牌的运行时状态
+ */
+func listTokenQuota(funnel *TokenFunnel, ctx *RequestContext, r *Request) (interface{}, error) {
+	data := make(map[string]interface{})
+	data["default_quota"] = funnel.GetDefaultTokenQuota()
+	data["tokens"] = funnel.ListTokenStats()
+	return data, nil
+}
+
+/**
+ * 修改指定令牌的配额，只允许修改已注册的令牌，避免误输入的名称产生无效配额
+ */
+func updateTokenQuota(funnel *TokenFunnel, ctx *RequestContext, r *Request) (interface{}, error) {
+	body, err := r.GetBody()
+	if err != nil {
+		return nil, err
+	}
+	update := new(TokenQuotaUpdate)
+	err = json.Unmarshal(body, update)
+	if err != nil {
+		return nil, fmt.Errorf("illegal token quota update: %s", err.Error())
+	}
+	if update.TokenName == "" {
+		return nil, errors.New("token name is empty")
+	}
+	if update.Quota < 0 {
+		return nil, fmt.Errorf("illegal token quota %d", update.Quota)
+	}
+	oldStat, ok := funnel.GetTokenStat(update.TokenName)
+	if !ok {
+		return nil, fmt.Errorf("token <%s> is not registered", update.TokenName)
+	}
+	funnel.SetTokenQuota(update.TokenName, update.Quota)
+	newStat, _ := funnel.GetTokenStat(update.TokenName)
+	logger.Info("%s token quota of <%s> updated by %s: %d -> %d", ctx.GetRequestId(), update.TokenName, ctx.GetClientIp(), oldStat.Quota, newStat.Quota)
+	return newStat, nil
+}
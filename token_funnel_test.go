@@ -31,3 +31,49 @@ func TestTokenBucket(t *testing.T) {
 	time.Sleep(time.Second)
 	wg.Wait()
 }
+
+func TestTokenQuotaReconfigure(t *testing.T) {
+	tokenFunnel := new(TokenFunnel)
+	tokenFunnel.Init()
+	tokenFunnel.SetDefaultTokenQuota(5)
+	tokenFunnel.AutocompleteTokenQuota("T1")
+	tokenFunnel.fullTokenPerSec()
+	if !tokenFunnel.TryGetToken("T1", nil) {
+		t.Fatal("token should be acquired")
+	}
+	tokenFunnel.SetTokenQuota("T1", 2)
+	stat, ok := tokenFunnel.GetTokenStat("T1")
+	if !ok {
+		t.Fatal("token stat of T1 not found")
+	}
+	if stat.Quota != 2 || stat.CurrentTokens != 2 || stat.AcquiredCount != 1 {
+		t.Fatalf("unexpected token stat: %+v", stat)
+	}
+	tokenFunnel.TryGetToken("T1", nil)
+	tokenFunnel.TryGetToken("T1", nil)
+	if tokenFunnel.TryGetToken("T1", nil) {
+		t.Fatal("token should be rejected")
+	}
+	stats := tokenFunnel.ListTokenStats()
+	if len(stats) != 1 || stats[0].RejectedCount != 1 || stats[0].AcquiredCount != 3 {
+		t.Fatalf("unexpected token stats: %+v", stats[0])
+	}
+}
+
+func TestTokenWaitTimeout(t *testing.T) {
+	//不启动Init中的定时投放协程，令牌只通过手动投放
+	tokenFunnel := new(TokenFunnel)
+	tokenFunnel.initBuckets()
+	tokenFunnel.SetTokenQuota("T1", 1)
+	tokenFunnel.fullTokenPerSec()
+	if !tokenFunnel.GetTokenWithTimeout("T1", nil, 10*time.Millisecond) {
+		t.Fatal("token should be acquired")
+	}
+	if tokenFunnel.GetTokenWithTimeout("T1", nil, 10*time.Millisecond) {
+		t.Fatal("token should be rejected after waiting timeout")
+	}
+	stat, _ := tokenFunnel.GetTokenStat("T1")
+	if stat.RejectedCount != 1 || stat.AcquiredCount != 1 || stat.TotalWaitCount != 1 || stat.WaitingCount != 0 {
+		t.Fatalf("unexpected token stat: %+v", stat)
+	}
+}
@@ -0,0 +1,180 @@
+package simpleapi
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/duhaifeng/simpleapi/db"
+)
+
+/**
+ * 定义声明式事务的Handler标记结构，Handler实现该接口并返回true时，框架会在HandleRequest前开启事务，
+ * HandleRequest返回nil错误时提交，返回错误或panic时回滚
+ */
+type IApiTransactional interface {
+	Transactional() bool
+}
+
+/**
+ * 注册一个声明式事务的请求路由，效果等同于Handler实现了IApiTransactional接口
+ */
+func (this *ApiServer) RegisterTxHandler(method, path string, handler interface{}) {
+	this.RegisterHandler(method, path, handler)
+	this.structHandlerDef[len(this.structHandlerDef)-1].Transactional = true
+}
+
+/**
+ * 判断一个路由是否需要声明式事务
+ */
+func isTransactionalRoute(handlerDef *StructHandlerDef, handlerObj IApiHandler) bool {
+	if handlerDef.Transactional {
+		return true
+	}
+	txMarker, ok := handlerObj.(IApiTransactional)
+	return ok && txMarker.Transactional()
+}
+
+/**
+ * 一次请求范围内的声明式事务
+ */
+type routeTransaction struct {
+	ctx      *RequestContext
+	tx       *db.GormProxy
+	finished bool
+}
+
+/**
+ * 在默认数据源上为请求开启声明式事务，事务被压入请求的事务栈，本次请求中使用默认数据源的Service都会加入该事务
+ */
+func (this *ApiServer) beginRouteTransaction(ctx *RequestContext) (*routeTransaction, error) {
+	if this.ormConn == nil {
+		return nil, errors.New("begin transaction failed. db conn is not opened")
+	}
+	tx, err := this.ormConn.Begin()
+	if err != nil {
+		return nil, err
+	}
+	logger.Debug("%s route transaction opened %p", ctx.GetRequestId(), tx)
+	ctx.pushTx(DEFAULT_DATA_SOURCE, tx)
+	return &routeTransaction{ctx: ctx, tx: tx}, nil
+}
+
+/**
+ * 结束声明式事务：err为nil时提交，否则回滚。重复调用时直接返回，因此请求结束时可以再调用一次，
+ * 用于回滚被拦截器提前拦截、没有到达Handler的请求所开启的事务
+ */
+func (this *routeTransaction) finish(err error) error {
+	if this.finished {
+		return nil
+	}
+	this.finished = true
+	this.ctx.popTx(this.tx)
+	if err != nil {
+		logger.Debug("%s rollback route transaction for error: %s", this.ctx.GetRequestId(), err.Error())
+		rollbackErr := this.tx.Rollback()
+		if rollbackErr != nil {
+			logger.Error("%s rollback route transaction failed: %s", this.ctx.GetRequestId(), rollbackErr.Error())
+		}
+		return rollbackErr
+	}
+	logger.Debug("%s commit route transaction", this.ctx.GetRequestId())
+	return this.tx.Commit()
+}
+
+/**
+ * 包装声明式事务Handler，在调用链的最后根据Handler的处理结果提交或回滚事务，保证事务在响应写回客户端之前结束
+ */
+type txHandler struct {
+	handler IApiHandler
+	routeTx *routeTransaction
+	BaseHandler
+}
+
+/**
+ * 调用被包装的Handler并结束事务，提交失败时将提交错误返回给客户端
+ */
+func (this *txHandler) HandleRequest(r *Request) (interface{}, error) {
+	defer func() {
+		err := recover()
+		if err != nil {
+			this.routeTx.finish(fmt.Errorf("panic: %v", err))
+			panic(err)
+		}
+	}()
+	resp, err := this.handler.HandleRequest(r)
+	txErr := this.routeTx.finish(err)
+	if err == nil && txErr != nil {
+		return nil, txErr
+	}
+	return resp, err
+}
+
+/**
+ * Service开启或加入的一层事务
+ */
+type serviceTx struct {
+	dataSource  string
+	propagation string
+	conn        *db.GormProxy //事务期间使用的连接
+	owned       bool          //是否由本层开启了新的数据库事务（或保存点），只有开启者才真正提交或回滚
+}
+
+/**
+ * 按传播方式在当前连接上开启一层事务：
+ * REQUIRED     当前有事务则加入，否则开启新事务
+ * REQUIRES_NEW 无论当前是否有事务，都从连接池开启一个独立的新事务
+ * NESTED       当前有事务则创建保存点，否则开启新事务
+ * SUPPORTS     当前有事务则加入，否则以非事务方式执行
+ */
+func beginServiceTx(current *db.GormProxy, propagation string) (*serviceTx, error) {
+	if current == nil {
+		return nil, errors.New("begin transaction failed. db conn is nil")
+	}
+	serviceTx := &serviceTx{propagation: propagation, conn: current}
+	inTx := current.IsInTx()
+	var err error
+	switch propagation {
+	case TX_PROPAGATION_REQUIRED:
+		if !inTx {
+			serviceTx.conn, err = current.Begin()
+			serviceTx.owned = true
+		}
+	case TX_PROPAGATION_REQUIRES_NEW:
+		serviceTx.conn, err = current.GetRoot().Begin()
+		serviceTx.owned = true
+	case TX_PROPAGATION_NESTED:
+		//在事务连接上Begin会创建保存点，否则开启新事务
+		serviceTx.conn, err = current.Begin()
+		serviceTx.owned = true
+	case TX_PROPAGATION_SUPPORTS:
+	default:
+		return nil, fmt.Errorf("unknown transaction propagation %s", propagation)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return serviceTx, nil
+}
+
+/**
+ * 提交本层事务：开启者提交事务（嵌套事务释放保存点），加入者不做处理
+ */
+func (this *serviceTx) commit() error {
+	if this.owned {
+		return this.conn.Commit()
+	}
+	return nil
+}
+
+/**
+ * 回滚本层事务：开启者回滚事务（嵌套事务回滚到保存点），REQUIRED加入者将外层事务标记为只能回滚
+ */
+func (this *serviceTx) rollback() error {
+	if this.owned {
+		return this.conn.Rollback()
+	}
+	if this.propagation == TX_PROPAGATION_REQUIRED && this.conn.IsInTx() {
+		this.conn.SetRollbackOnly()
+	}
+	return nil
+}
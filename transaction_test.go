@@ -0,0 +1,405 @@
+package simpleapi
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/duhaifeng/simpleapi/db"
+	"github.com/go-sql-driver/mysql"
+)
+
+type txTestOrder struct {
+	Id   int64
+	Name string
+}
+
+type txTestOrderDb struct {
+	BaseDbOperator
+}
+
+func (this *txTestOrderDb) add(name string) error {
+	return this.OrmConn().Create(&txTestOrder{Name: name}).Error
+}
+
+/**
+ * 最近一次请求销毁txTestOrderService时收到的错误
+ */
+var txTestDestroyErr error
+
+type txTestOrderService struct {
+	Db *txTestOrderDb
+	BaseService
+}
+
+func (this *txTestOrderService) Destroy(err error) {
+	txTestDestroyErr = err
+}
+
+type txTestRouteHandler struct {
+	Order *txTestOrderService
+	BaseHandler
+}
+
+func (this *txTestRouteHandler) HandleRequest(r *Request) (interface{}, error) {
+	return handleTxTestOrder(this.Order, r)
+}
+
+/**
+ * 按请求参数保存订单，result为error时返回错误，为panic时panic
+ */
+func handleTxTestOrder(order *txTestOrderService, r *Request) (interface{}, error) {
+	err := order.Db.add(r.GetUrlParam("name"))
+	if err != nil {
+		return nil, err
+	}
+	switch r.GetUrlParam("result") {
+	case "error":
+		return nil, errors.New("order failed")
+	case "panic":
+		panic("order panic")
+	}
+	return nil, nil
+}
+
+type txTestMarkedHandler struct {
+	Order *txTestOrderService
+	BaseHandler
+}
+
+func (this *txTestMarkedHandler) HandleRequest(r *Request) (interface{}, error) {
+	return handleTxTestOrder(this.Order, r)
+}
+
+func (this *txTestMarkedHandler) Transactional() bool {
+	return true
+}
+
+/**
+ * 创建使用SQLite文件数据源的测试服务器，文件数据库允许多个连接，用于测试独立事务
+ */
+func newTxTestServer(t *testing.T) (*ApiServer, *db.GormProxy) {
+	conn := new(db.GormProxy)
+	err := conn.OpenSqlite(filepath.Join(t.TempDir(), "tx.db") + "?_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		conn.Close()
+	})
+	err = conn.Conn.AutoMigrate(new(txTestOrder))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := new(ApiServer)
+	s.Init()
+	s.GetTokenFunnel().SetDefaultTokenQuota(100)
+	s.RegisterDataSource(DEFAULT_DATA_SOURCE, conn)
+	return s, conn
+}
+
+/**
+ * 判断名称对应的订单是否已经保存
+ */
+func txTestOrderSaved(t *testing.T, conn *db.GormProxy, name string) bool {
+	var count int64
+	err := conn.Conn.Model(new(txTestOrder)).Where("name = ?", name).Count(&count).Error
+	if err != nil {
+		t.Fatal(err)
+	}
+	return count > 0
+}
+
+func TestRouteTransaction(t *testing.T) {
+	s, conn := newTxTestServer(t)
+	s.RegisterTxHandler(http.MethodGet, "/order", txTestRouteHandler{})
+	s.RegisterHandler(http.MethodGet, "/marked", txTestMarkedHandler{})
+	s.RegisterHandler(http.MethodGet, "/plain", txTestRouteHandler{})
+	s.registerStructHandlerRoute()
+	s.GetTokenFunnel().fullTokenPerSec()
+	serve := func(url string) {
+		recorder := httptest.NewRecorder()
+		s.httpRouter.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, url, nil))
+	}
+
+	serve("/order?name=committed")
+	if !txTestOrderSaved(t, conn, "committed") || txTestDestroyErr != nil {
+		t.Fatalf("route transaction should be committed: %v", txTestDestroyErr)
+	}
+	serve("/order?name=failed&result=error")
+	if txTestOrderSaved(t, conn, "failed") {
+		t.Fatal("route transaction should be rolled back when the handler fails")
+	}
+	serve("/order?name=panicked&result=panic")
+	if txTestOrderSaved(t, conn, "panicked") || txTestDestroyErr == nil {
+		t.Fatalf("route transaction should be rolled back and services destroyed on panic: %v", txTestDestroyErr)
+	}
+	//回滚后连接已经归还，后续请求仍然可以写入
+	serve("/marked?name=marked-failed&result=error")
+	serve("/marked?name=marked")
+	if txTestOrderSaved(t, conn, "marked-failed") || !txTestOrderSaved(t, conn, "marked") {
+		t.Fatal("handler implementing IApiTransactional should run in a route transaction")
+	}
+	serve("/plain?name=plain&result=error")
+	if !txTestOrderSaved(t, conn, "plain") {
+		t.Fatal("non transactional route should not roll back its writes")
+	}
+}
+
+type txTestInnerService struct {
+	Db *txTestOrderDb
+	BaseService
+}
+
+/**
+ * 按传播方式在事务中保存订单，fail为true时保存后返回错误使本层事务回滚
+ */
+func (this *txTestInnerService) addWith(propagation, name string, fail bool) error {
+	return this.InTransactionWith(propagation, func() error {
+		err := this.Db.add(name)
+		if err != nil {
+			return err
+		}
+		if fail {
+			return errors.New("inner failed")
+		}
+		return nil
+	})
+}
+
+type txTestOuterService struct {
+	Db *txTestOrderDb
+	BaseService
+}
+
+type txTestServiceHandler struct {
+	Outer *txTestOuterService
+	Inner *txTestInnerService
+	BaseHandler
+}
+
+func (this *txTestServiceHandler) HandleRequest(r *Request) (interface{}, error) {
+	return nil, nil
+}
+
+/**
+ * 为一次模拟的请求构造Handler，同一个Handler中的Service共享请求的事务栈
+ */
+func newTxTestServiceHandler(t *testing.T, s *ApiServer) *txTestServiceHandler {
+	plan, err := s.buildHandlerInjectPlan(reflect.TypeOf(txTestServiceHandler{}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := new(RequestContext)
+	ctx.Init()
+	return s.assembleServiceToHandler(reflect.New(plan.handlerType), plan, newServiceScope(ctx)).Interface().(*txTestServiceHandler)
+}
+
+func TestTxPropagation(t *testing.T) {
+	s, conn := newTxTestServer(t)
+
+	handler := newTxTestServiceHandler(t, s)
+	err := handler.Outer.InTransaction(func() error {
+		err := handler.Outer.Db.add("required-outer")
+		if err != nil {
+			return err
+		}
+		return handler.Inner.addWith(TX_PROPAGATION_REQUIRED, "required-inner", false)
+	})
+	if err != nil || !txTestOrderSaved(t, conn, "required-outer") || !txTestOrderSaved(t, conn, "required-inner") {
+		t.Fatalf("REQUIRED should join and commit with the outer transaction: %v", err)
+	}
+
+	//REQUIRED加入者回滚时将外层事务标记为只能回滚，外层提交时整个事务回滚
+	handler = newTxTestServiceHandler(t, s)
+	err = handler.Outer.InTransaction(func() error {
+		err := handler.Outer.Db.add("rollback-only-outer")
+		if err != nil {
+			return err
+		}
+		handler.Inner.addWith(TX_PROPAGATION_REQUIRED, "rollback-only-inner", true)
+		return nil
+	})
+	if err == nil || txTestOrderSaved(t, conn, "rollback-only-outer") || txTestOrderSaved(t, conn, "rollback-only-inner") {
+		t.Fatalf("rollback of a REQUIRED joiner should make the outer commit fail and roll back: %v", err)
+	}
+
+	//SQLite同时只允许一个写事务，因此独立事务在外层事务写入前执行
+	handler = newTxTestServiceHandler(t, s)
+	err = handler.Outer.InTransaction(func() error {
+		err := handler.Inner.addWith(TX_PROPAGATION_REQUIRES_NEW, "requires-new-inner", false)
+		if err != nil {
+			return err
+		}
+		err = handler.Outer.Db.add("requires-new-outer")
+		if err != nil {
+			return err
+		}
+		return errors.New("outer failed")
+	})
+	if err == nil || !txTestOrderSaved(t, conn, "requires-new-inner") || txTestOrderSaved(t, conn, "requires-new-outer") {
+		t.Fatalf("REQUIRES_NEW should commit independently of the outer transaction: %v", err)
+	}
+
+	handler = newTxTestServiceHandler(t, s)
+	err = handler.Outer.InTransaction(func() error {
+		err := handler.Outer.Db.add("nested-outer")
+		if err != nil {
+			return err
+		}
+		handler.Inner.addWith(TX_PROPAGATION_NESTED, "nested-inner", true)
+		return nil
+	})
+	if err != nil || !txTestOrderSaved(t, conn, "nested-outer") || txTestOrderSaved(t, conn, "nested-inner") {
+		t.Fatalf("NESTED should only roll back to its savepoint: %v", err)
+	}
+
+	handler = newTxTestServiceHandler(t, s)
+	err = handler.Inner.addWith(TX_PROPAGATION_SUPPORTS, "supports-alone", true)
+	if err == nil || !txTestOrderSaved(t, conn, "supports-alone") {
+		t.Fatalf("SUPPORTS without a transaction should run non-transactionally: %v", err)
+	}
+	err = handler.Outer.InTransaction(func() error {
+		err := handler.Inner.addWith(TX_PROPAGATION_SUPPORTS, "supports-joined", false)
+		if err != nil {
+			return err
+		}
+		return errors.New("outer failed")
+	})
+	if err == nil || txTestOrderSaved(t, conn, "supports-joined") {
+		t.Fatalf("SUPPORTS should join the outer transaction: %v", err)
+	}
+}
+
+func TestTxCallbackOrder(t *testing.T) {
+	s, _ := newTxTestServer(t)
+	var events []string
+	record := func(event string) func(ctx *RequestContext) {
+		return func(ctx *RequestContext) {
+			events = append(events, event)
+		}
+	}
+
+	handler := newTxTestServiceHandler(t, s)
+	err := handler.Outer.InTransaction(func() error {
+		handler.Outer.OnCommit(record("outer-commit"))
+		handler.Outer.OnRollback(record("outer-rollback"))
+		err := handler.Inner.InTransactionWith(TX_PROPAGATION_NESTED, func() error {
+			handler.Inner.OnCommit(record("released-commit"))
+			handler.Inner.OnRollback(record("released-rollback"))
+			return handler.Inner.Db.add("released")
+		})
+		if err != nil {
+			return err
+		}
+		if len(events) != 0 {
+			t.Fatalf("callbacks of a released savepoint should wait for the outer transaction: %v", events)
+		}
+		handler.Inner.InTransactionWith(TX_PROPAGATION_NESTED, func() error {
+			handler.Inner.OnCommit(record("failed-commit"))
+			handler.Inner.OnRollback(record("failed-rollback"))
+			return errors.New("nested failed")
+		})
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(events, []string{"failed-rollback", "outer-commit", "released-commit"}) {
+		t.Fatalf("unexpected callback order after commit: %v", events)
+	}
+
+	//已释放的保存点的回滚回调随外层事务的回滚执行
+	events = nil
+	handler = newTxTestServiceHandler(t, s)
+	handler.Outer.InTransaction(func() error {
+		handler.Outer.OnCommit(record("outer-commit"))
+		handler.Outer.OnRollback(record("outer-rollback"))
+		handler.Inner.InTransactionWith(TX_PROPAGATION_NESTED, func() error {
+			handler.Inner.OnCommit(record("released-commit"))
+			handler.Inner.OnRollback(record("released-rollback"))
+			return nil
+		})
+		return errors.New("outer failed")
+	})
+	if !reflect.DeepEqual(events, []string{"outer-rollback", "released-rollback"}) {
+		t.Fatalf("unexpected callback order after rollback: %v", events)
+	}
+
+	//不在事务中时提交回调立即执行，回滚回调被忽略
+	events = nil
+	handler = newTxTestServiceHandler(t, s)
+	handler.Outer.OnCommit(record("no-tx-commit"))
+	handler.Outer.OnRollback(record("no-tx-rollback"))
+	if !reflect.DeepEqual(events, []string{"no-tx-commit"}) {
+		t.Fatalf("unexpected callbacks outside transaction: %v", events)
+	}
+}
+
+func TestInRetryTransaction(t *testing.T) {
+	s, conn := newTxTestServer(t)
+	policy := db.TxRetryPolicy{MaxAttempts: 3, InitialBackoff: time.Millisecond, MaxBackoff: 2 * time.Millisecond}
+	deadlock := &mysql.MySQLError{Number: db.MYSQL_ERR_DEADLOCK, Message: "Deadlock found when trying to get lock"}
+	countOrders := func(name string) int64 {
+		var count int64
+		err := conn.Conn.Model(new(txTestOrder)).Where("name = ?", name).Count(&count).Error
+		if err != nil {
+			t.Fatal(err)
+		}
+		return count
+	}
+
+	//每次失败的尝试都被回滚，成功的一次只写入一条记录
+	handler := newTxTestServiceHandler(t, s)
+	attempts := 0
+	err := handler.Outer.InRetryTransactionWith(policy, func() error {
+		attempts++
+		err := handler.Outer.Db.add("retried")
+		if err != nil {
+			return err
+		}
+		if attempts < 3 {
+			return deadlock
+		}
+		return nil
+	})
+	if err != nil || attempts != 3 || countOrders("retried") != 1 {
+		t.Fatalf("deadlocked attempts should be rolled back and retried: %v %d %d", err, attempts, countOrders("retried"))
+	}
+
+	attempts = 0
+	err = handler.Outer.InRetryTransactionWith(policy, func() error {
+		attempts++
+		handler.Outer.Db.add("exhausted")
+		return deadlock
+	})
+	if !errors.Is(err, deadlock) || attempts != policy.MaxAttempts || countOrders("exhausted") != 0 {
+		t.Fatalf("retry should stop after max attempts: %v %d", err, attempts)
+	}
+
+	attempts = 0
+	err = handler.Outer.InRetryTransactionWith(policy, func() error {
+		attempts++
+		return errors.New("validation failed")
+	})
+	if err == nil || attempts != 1 {
+		t.Fatalf("non retryable error should not be retried: %v %d", err, attempts)
+	}
+
+	//加入外层事务时不重试，由开启事务的一方处理
+	attempts = 0
+	handler = newTxTestServiceHandler(t, s)
+	err = handler.Outer.InTransaction(func() error {
+		return handler.Inner.InRetryTransactionWith(policy, func() error {
+			attempts++
+			return deadlock
+		})
+	})
+	if !errors.Is(err, deadlock) || attempts != 1 {
+		t.Fatalf("joined retry transaction should not retry: %v %d", err, attempts)
+	}
+}